@@ -1,13 +1,34 @@
 package anka
 
 import (
-	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	registryimage "github.com/hashicorp/packer-plugin-sdk/packer/registry/image"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+	"github.com/veertuinc/packer-builder-veertu-anka/common"
 )
 
 // Artifact represents an Anka image as the result of a Packer build.
 type Artifact struct {
 	vmName string
 	vmId   string
+
+	// state holds what is known about the VM, keyed like its State names
+	state map[string]string
+
+	client  *client.Client
+	destroy destroyOptions
+}
+
+// destroyOptions are the destroy_* settings of the build.
+type destroyOptions struct {
+	withRunningClones bool
+	pushedTemplate    bool
+	registryTemplate  bool
+	registryTag       string
 }
 
 // BuilderId returns the builder Id.
@@ -15,11 +36,97 @@ func (*Artifact) BuilderId() string {
 	return BuilderId
 }
 
-// Destroy destroys the image represented by the artifact.
+// Destroy stops and deletes the VM. It refuses to delete a VM that running
+// clones were made from, or one that was pushed to the registry, unless the
+// configuration allows it.
 func (self *Artifact) Destroy() error {
-	// log.Printf("Destroying image: %s", self.String())
-	// err := self.client.destroyImage(self.imageId)
-	return errors.New("Destroy not implemented")
+	log.Printf("Destroying VM: %s", self.vmName)
+
+	show, err := self.client.Show(self.vmId)
+	if err != nil {
+		if _, ok := err.(*common.VMNotFoundException); ok {
+			log.Printf("VM %s doesn't exist anymore", self.vmName)
+			return nil
+		}
+		return err
+	}
+
+	if !self.destroy.withRunningClones {
+		clones, err := self.runningClones(show)
+		if err != nil {
+			return err
+		}
+		if len(clones) > 0 {
+			return fmt.Errorf("not destroying VM %s, running clones share its image: %s (set destroy_with_running_clones to destroy it anyway)",
+				self.vmName, strings.Join(clones, ", "))
+		}
+	}
+
+	pushed, err := self.pushed()
+	if err != nil {
+		// Only destroy_pushed_template makes it safe to go on without
+		// knowing
+		if !self.destroy.pushedTemplate || self.destroy.registryTemplate {
+			return fmt.Errorf("not destroying VM %s, unable to check whether it was pushed to the registry (set destroy_pushed_template to destroy it anyway): %w", self.vmName, err)
+		}
+		log.Printf("Unable to check whether VM %s was pushed to the registry: %v", self.vmName, err)
+	}
+	if pushed && !self.destroy.pushedTemplate && !self.destroy.registryTemplate {
+		return fmt.Errorf("not destroying VM %s, it was pushed to the registry (set destroy_pushed_template to destroy it anyway)", self.vmName)
+	}
+
+	if show.IsRunning() {
+		if err := self.client.Stop(client.StopParams{VMName: self.vmId, Force: true}); err != nil {
+			return err
+		}
+	}
+	if err := self.client.Delete(client.DeleteParams{VMName: self.vmId}); err != nil {
+		return err
+	}
+
+	if pushed && self.destroy.registryTemplate {
+		log.Printf("Deleting VM %s from the registry", self.vmName)
+		return self.client.RegistryDelete(self.vmId, self.destroy.registryTag)
+	}
+	return nil
+}
+
+// runningClones returns the names of other running VMs sharing the image of
+// the VM.
+func (self *Artifact) runningClones(show client.ShowResponse) ([]string, error) {
+	vms, err := self.client.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var clones []string
+	for _, vm := range vms {
+		if vm.UUID == self.vmId {
+			continue
+		}
+		other, err := self.client.Show(vm.UUID)
+		if err != nil {
+			return nil, err
+		}
+		if other.ImageID == show.ImageID && other.IsRunning() {
+			clones = append(clones, other.Name)
+		}
+	}
+	return clones, nil
+}
+
+// pushed checks whether the VM is in the registry.
+func (self *Artifact) pushed() (bool, error) {
+	templates, err := self.client.RegistryList()
+	if err != nil {
+		return false, err
+	}
+	for _, template := range templates {
+		if template.ID == self.vmId {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 // Files returns the files represented by the artifact.
@@ -32,11 +139,61 @@ func (self *Artifact) Id() string {
 	return self.vmId
 }
 
+// State returns what is known about the VM: vm_id, vm_name, source_vm_name,
+// source_vm_id, cpu_count, ram_size, disk_size, htt, anka_version,
+// license_type, registry_tag, source_registry_tag and macos_version, as
+// strings. Unknown values are empty.
 func (self *Artifact) State(name string) interface{} {
-	return nil
+	if name == registryimage.ArtifactStateURI {
+		return self.registryImage()
+	}
+	value, ok := self.state[name]
+	if !ok {
+		return nil
+	}
+	return value
 }
 
 // String returns the string representation of the artifact.
 func (self *Artifact) String() string {
+	if version := self.state["macos_version"]; version != "" {
+		return fmt.Sprintf("%s (macOS %s)", self.vmName, version)
+	}
 	return self.vmName
 }
+
+// registryTag returns the latest registry tag of the template with id, or
+// an empty string when it isn't in the registry.
+func registryTag(templates []client.RegistryListResponse, id string) string {
+	for _, template := range templates {
+		if id != "" && template.ID == id {
+			return template.Latest
+		}
+	}
+	return ""
+}
+
+// registryImage is the metadata the HCP Packer registry indexes the VM with.
+func (self *Artifact) registryImage() *registryimage.Image {
+	labels := make(map[string]interface{}, len(self.state))
+	for key, value := range self.state {
+		if value != "" {
+			labels[key] = value
+		}
+	}
+
+	// Anka VMs live on the host that built them
+	region, _ := os.Hostname()
+
+	image, err := registryimage.FromArtifact(self,
+		registryimage.WithProvider("anka"),
+		registryimage.WithRegion(region),
+		registryimage.WithSourceID(self.state["source_vm_id"]),
+		registryimage.SetLabels(labels),
+	)
+	if err != nil {
+		log.Printf("Unable to create the registry image of VM %s: %v", self.vmName, err)
+		return nil
+	}
+	return image
+}
@@ -0,0 +1,185 @@
+package anka
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	registryimage "github.com/hashicorp/packer-plugin-sdk/packer/registry/image"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+func TestArtifact_impl(t *testing.T) {
+	var _ packer.Artifact = new(Artifact)
+}
+
+func TestArtifactState(t *testing.T) {
+	artifact := &Artifact{
+		vmId:   "c0847bc9-5d2d-4dbc-ba6a-240f7ff08032",
+		vmName: "macos-11.2-xcode",
+		state: map[string]string{
+			"vm_id":          "c0847bc9-5d2d-4dbc-ba6a-240f7ff08032",
+			"vm_name":        "macos-11.2-xcode",
+			"source_vm_name": "macos-11.2",
+			"source_vm_id":   "1b3f0a4e-0c8c-4c8a-9a8e-5a3c7e7a1f2d",
+			"cpu_count":      "4",
+			"macos_version":  "11.2.3",
+			"license_type":   "",
+		},
+	}
+
+	if artifact.State("cpu_count") != "4" {
+		t.Fatalf("Unexpected cpu_count %v", artifact.State("cpu_count"))
+	}
+	if artifact.State("unknown") != nil {
+		t.Fatalf("Unexpected state for an unknown name")
+	}
+	if artifact.String() != "macos-11.2-xcode (macOS 11.2.3)" {
+		t.Fatalf("Unexpected string %q", artifact.String())
+	}
+
+	image, ok := artifact.State(registryimage.ArtifactStateURI).(*registryimage.Image)
+	if !ok {
+		t.Fatalf("Unexpected registry metadata %#v", artifact.State(registryimage.ArtifactStateURI))
+	}
+	if image.ImageID != artifact.vmId || image.ProviderName != "anka" || image.SourceImageID != "1b3f0a4e-0c8c-4c8a-9a8e-5a3c7e7a1f2d" {
+		t.Fatalf("Unexpected registry image %#v", image)
+	}
+	if err := image.Validate(); err != nil {
+		t.Fatalf("Invalid registry image: %s", err)
+	}
+	if image.Labels["macos_version"] != "11.2.3" {
+		t.Fatalf("Unexpected labels %v", image.Labels)
+	}
+	if _, ok := image.Labels["license_type"]; ok {
+		t.Fatalf("Empty values shouldn't be labels: %v", image.Labels)
+	}
+}
+
+func destroyResponses(cloneStatus string, pushed bool) map[string]string {
+	registry := `{"status": "OK", "body": []}`
+	if pushed {
+		registry = `{"status": "OK", "body": [{"id": "template-id", "name": "template"}]}`
+	}
+	return map[string]string{
+		"show template-id": `{"status": "OK", "body": {"uuid": "template-id", "name": "template", "image_id": "image", "status": "running"}}`,
+		"show clone-id":    `{"status": "OK", "body": {"uuid": "clone-id", "name": "clone", "image_id": "image", "status": "` + cloneStatus + `"}}`,
+		"list":             `{"status": "OK", "body": [{"uuid": "template-id", "name": "template"}, {"uuid": "clone-id", "name": "clone"}]}`,
+		"registry list":    registry,
+	}
+}
+
+func TestArtifactDestroy(t *testing.T) {
+	fake := withFakeAnka(t, destroyResponses("stopped", false))
+
+	artifact := &Artifact{vmId: "template-id", vmName: "template", client: &client.Client{}}
+	if err := artifact.Destroy(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	expected := []string{"show template-id", "list", "show clone-id", "registry list", "stop --force template-id", "delete --yes template-id"}
+	if !reflect.DeepEqual(fake.calls(), expected) {
+		t.Fatalf("Unexpected commands %q", fake.calls())
+	}
+}
+
+func TestArtifactDestroyRunningClones(t *testing.T) {
+	fake := withFakeAnka(t, destroyResponses("running", false))
+
+	artifact := &Artifact{vmId: "template-id", vmName: "template", client: &client.Client{}}
+	err := artifact.Destroy()
+	if err == nil || !strings.Contains(err.Error(), "clone") {
+		t.Fatalf("Expected running clones to prevent destroying, got %v", err)
+	}
+	for _, call := range fake.calls() {
+		if strings.HasPrefix(call, "delete") {
+			t.Fatalf("Unexpected delete")
+		}
+	}
+
+	artifact.destroy.withRunningClones = true
+	if err := artifact.Destroy(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+func TestArtifactDestroyPushed(t *testing.T) {
+	fake := withFakeAnka(t, destroyResponses("stopped", true))
+
+	artifact := &Artifact{vmId: "template-id", vmName: "template", client: &client.Client{}}
+	err := artifact.Destroy()
+	if err == nil || !strings.Contains(err.Error(), "registry") {
+		t.Fatalf("Expected the registry push to prevent destroying, got %v", err)
+	}
+
+	artifact.destroy.registryTemplate = true
+	artifact.destroy.registryTag = "v1"
+	if err := artifact.Destroy(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	commands := fake.calls()
+	if last := commands[len(commands)-1]; last != "registry delete --id template-id --tag v1" {
+		t.Fatalf("Unexpected commands %q", commands)
+	}
+}
+
+func TestArtifactDestroyMissing(t *testing.T) {
+	withFakeAnka(t, map[string]string{
+		"show template-id": `{"status": "ERROR", "code": 3, "message": "not found"}`,
+	})
+
+	artifact := &Artifact{vmId: "template-id", vmName: "template", client: &client.Client{}}
+	if err := artifact.Destroy(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+func TestRegistryTag(t *testing.T) {
+	templates := []client.RegistryListResponse{
+		{ID: "source-id", Name: "macos-11.2", Latest: "v3"},
+		{ID: "vm-id", Name: "macos-11.2-xcode", Latest: "v1"},
+	}
+
+	if tag := registryTag(templates, "vm-id"); tag != "v1" {
+		t.Fatalf("Unexpected tag %q for a pushed VM", tag)
+	}
+	if tag := registryTag(templates, "source-id"); tag != "v3" {
+		t.Fatalf("Unexpected tag %q for a source VM in the registry", tag)
+	}
+	// A VM that wasn't pushed doesn't get the tag of its source
+	if tag := registryTag(templates, "other-id"); tag != "" {
+		t.Fatalf("Unexpected tag %q for a VM that wasn't pushed", tag)
+	}
+	if tag := registryTag(templates, ""); tag != "" {
+		t.Fatalf("Unexpected tag %q", tag)
+	}
+}
+
+func TestArtifactDestroyRegistryUnavailable(t *testing.T) {
+	responses := destroyResponses("stopped", false)
+	responses["registry list"] = `{"status": "ERROR", "code": 1, "message": "registry unreachable"}`
+	fake := withFakeAnka(t, responses)
+
+	artifact := &Artifact{vmId: "template-id", vmName: "template", client: &client.Client{}}
+	err := artifact.Destroy()
+	if err == nil || !strings.Contains(err.Error(), "registry unreachable") {
+		t.Fatalf("Expected the registry error to prevent destroying, got %v", err)
+	}
+	for _, call := range fake.calls() {
+		if strings.HasPrefix(call, "delete") {
+			t.Fatalf("Unexpected delete")
+		}
+	}
+
+	artifact.destroy.registryTemplate = true
+	if err := artifact.Destroy(); err == nil {
+		t.Fatal("Expected an error deleting from an unavailable registry")
+	}
+
+	artifact.destroy.registryTemplate = false
+	artifact.destroy.pushedTemplate = true
+	if err := artifact.Destroy(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
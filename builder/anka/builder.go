@@ -2,8 +2,8 @@ package anka
 
 import (
 	"context"
-	"errors"
 	"log"
+	"strconv"
 
 	"github.com/hashicorp/hcl/v2/hcldec"
 	"github.com/hashicorp/packer-plugin-sdk/communicator"
@@ -30,12 +30,26 @@ func (b *Builder) Prepare(raws ...interface{}) (params []string, warns []string,
 		return nil, nil, errs
 	}
 	b.config = c
-	return nil, nil, nil
+	return generatedDataNames, nil, nil
 }
 
 // Run executes an Anka Packer build and returns a packer.Artifact
 func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (packer.Artifact, error) {
-	client := &client.Client{}
+	var auditLog *client.AuditLog
+	if b.config.AnkaAuditLog != "" {
+		var err error
+		auditLog, err = client.NewAuditLog(b.config.AnkaAuditLog)
+		if err != nil {
+			return nil, err
+		}
+		defer auditLog.Close()
+	}
+
+	client := &client.Client{AuditLog: auditLog}
+	// The audit log is closed once the build is done, so destroying the
+	// artifact later runs without it
+	artifactClient := *client
+	artifactClient.AuditLog = nil
 
 	version, err := client.Version()
 	if err != nil {
@@ -46,18 +60,26 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 	steps := []multistep.Step{
 		&StepTempDir{},
 		&StepCreateVM{},
+		&StepDiagnostics{},
 		&StepSetHyperThreading{},
 		&StepStartVM{},
+		&StepBootCommand{},
+		&StepForwardSSH{},
 		&communicator.StepConnect{
 			Config: &b.config.Comm,
 			CustomConnect: map[string]multistep.Step{
 				"anka": &StepConnectAnka{},
 			},
-			Host: func(state multistep.StateBag) (string, error) {
-				return "", errors.New("No host implemented for anka builder (which is ok)")
-			},
+			Host:    sshHost,
+			SSHPort: sshPort,
 		},
+		// Again once connected, so the guest is collected from before the
+		// SSH port forward is removed
+		&StepDiagnostics{},
+		&StepGuestInfo{},
+		&StepGeneratedData{},
 		&commonsteps.StepProvision{},
+		&StepFinalizeVM{},
 	}
 
 	// Setup the state bag and initial state for the steps
@@ -66,6 +88,7 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 	state.Put("hook", hook)
 	state.Put("ui", ui)
 	state.Put("client", client)
+	state.Put("anka_version", version.Body.Version)
 
 	// Run!
 	b.runner = commonsteps.NewRunner(steps, b.config.PackerConfig, ui)
@@ -86,10 +109,49 @@ func (b *Builder) Run(ctx context.Context, ui packer.Ui, hook packer.Hook) (pack
 	if err != nil {
 		return nil, err
 	}
+	show, err := client.Show(descr.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	artifactState := map[string]string{
+		"vm_id":        descr.UUID,
+		"vm_name":      descr.Name,
+		"cpu_count":    strconv.Itoa(descr.CPU.Cores),
+		"ram_size":     descr.RAM,
+		"disk_size":    strconv.FormatUint(show.HardDrive, 10),
+		"htt":          strconv.FormatBool(descr.CPU.Threads > 0),
+		"anka_version": version.Body.Version,
+	}
+	for _, key := range []string{"source_vm_name", "source_vm_id", "macos_version", "macos_build", "macos_arch", "installer_app", "installer_version"} {
+		if value, ok := state.GetOk(key); ok {
+			artifactState[key] = value.(string)
+		}
+	}
+	if templates, err := client.RegistryList(); err == nil {
+		artifactState["registry_tag"] = registryTag(templates, descr.UUID)
+		artifactState["source_registry_tag"] = registryTag(templates, artifactState["source_vm_id"])
+	} else {
+		log.Printf("Unable to list the registry: %v", err)
+	}
+	if license, err := client.License(); err == nil {
+		artifactState["license_type"] = license.LicenseType
+	} else {
+		log.Printf("Unable to show the anka license: %v", err)
+	}
+
 	// No errors, must've worked
 	return &Artifact{
 		vmId:   descr.UUID,
 		vmName: descr.Name,
+		state:  artifactState,
+		client: &artifactClient,
+		destroy: destroyOptions{
+			withRunningClones: b.config.DestroyWithRunningClones,
+			pushedTemplate:    b.config.DestroyPushedTemplate,
+			registryTemplate:  b.config.DestroyRegistryTemplate,
+			registryTag:       b.config.DestroyRegistryTag,
+		},
 	}, nil
 }
 
@@ -17,7 +17,11 @@ func TestPrepare(t *testing.T) {
 
 	c := testConfig()
 
-	if _, _, err := b.Prepare(c); err != nil {
+	generatedData, _, err := b.Prepare(c)
+	if err != nil {
 		t.Fatalf("Unexpected error: %s", err)
 	}
+	if len(generatedData) != len(generatedDataNames) {
+		t.Fatalf("Unexpected generated data %v", generatedData)
+	}
 }
@@ -8,6 +8,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"context"
 
@@ -16,6 +17,7 @@ import (
 	oldPacker "github.com/hashicorp/packer/packer"
 	"github.com/hashicorp/packer/provisioner/file"
 	"github.com/hashicorp/packer/provisioner/shell"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
 )
 
 func TestCommunicator_impl(t *testing.T) {
@@ -235,3 +237,26 @@ const ankaBuilderShellConfig = `
   ]
 }
 `
+
+func TestCommunicatorStartCancel(t *testing.T) {
+	withFakeAnka(t, nil)
+
+	comm := &Communicator{Config: &Config{}, Client: &client.Client{}, VMName: "fake"}
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := &packer.RemoteCmd{Command: "sleep 30"}
+	if err := comm.Start(ctx, cmd); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	cancel()
+
+	exited := make(chan int, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case status := <-exited:
+		if status == 0 {
+			t.Fatal("Expected the cancelled command to fail")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("The command wasn't stopped when its context was cancelled")
+	}
+}
@@ -1,11 +1,18 @@
-//go:generate mapstructure-to-hcl2 -type Config
+//go:generate mapstructure-to-hcl2 -type Config,PortForwardingRule,NetworkCard,OnErrorCollect
 package anka
 
 import (
 	"errors"
 	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/packer-plugin-sdk/bootcommand"
 	"github.com/hashicorp/packer-plugin-sdk/common"
 	"github.com/hashicorp/packer-plugin-sdk/communicator"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
@@ -15,12 +22,74 @@ import (
 )
 
 const DEFAULT_BOOT_DELAY = "10s"
+const DEFAULT_REBOOT_TIMEOUT = 10 * time.Minute
+const DEFAULT_LOCK_TIMEOUT = 3 * time.Hour
+
+var DEFAULT_INSTALLER_SEARCH_PATHS = []string{"/Applications"}
+
+// knownCustomVariables are the VM custom variables anka accepts with
+// `anka modify <vm> set custom-variable`.
+var knownCustomVariables = map[string]struct{}{
+	"hw.UUID":     {},
+	"hw.serial":   {},
+	"hw.model":    {},
+	"hw.family":   {},
+	"hw.product":  {},
+	"hw.board-id": {},
+	"hw.MLB":      {},
+	"hw.ROM":      {},
+}
+
+var networkModes = map[string]struct{}{
+	"shared":       {},
+	"host":         {},
+	"bridged":      {},
+	"bridge":       {},
+	"disconnected": {},
+}
+
+var networkCardTypes = map[string]struct{}{
+	"virtio-net": {},
+	"e1000":      {},
+}
+
+var guestShells = map[string]struct{}{
+	"sh":   {},
+	"bash": {},
+	"zsh":  {},
+}
+
+var envVariableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func knownCustomVariableNames() []string {
+	names := make([]string, 0, len(knownCustomVariables))
+	for name := range knownCustomVariables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
 
 type Config struct {
 	common.PackerConfig `mapstructure:",squash"`
-	Comm                communicator.Config `mapstructure:",squash"`
+	Comm                communicator.Config   `mapstructure:",squash"`
+	VNCConfig           bootcommand.VNCConfig `mapstructure:",squash"`
 
 	InstallerApp string `mapstructure:"installer_app"`
+	// InstallerVersion picks the highest installer in InstallerSearchPaths
+	// whose version matches this constraint, instead of InstallerApp.
+	InstallerVersion     string   `mapstructure:"installer_version"`
+	InstallerSearchPaths []string `mapstructure:"installer_search_paths"`
+	// BaseVMName is the template for the name of the base VM created from
+	// the installer, rendered with its MacOSVersion, InstallerVersion and
+	// Build.
+	BaseVMName     string `mapstructure:"base_vm_name"`
+	RebuildBaseVM  bool   `mapstructure:"rebuild_base_vm"`
+	BaseVMMismatch string `mapstructure:"base_vm_mismatch"`
+	// LockTimeout is how long to wait for concurrent builds using the same
+	// source or base VM.
+	LockTimeout time.Duration `mapstructure:"lock_timeout"`
+
 	SourceVMName string `mapstructure:"source_vm_name"`
 
 	VMName   string `mapstructure:"vm_name"`
@@ -28,29 +97,104 @@ type Config struct {
 	RAMSize  string `mapstructure:"ram_size"`
 	CPUCount string `mapstructure:"cpu_count"`
 
-	PortForwardingRules []struct {
-		PortForwardingGuestPort int    `mapstructure:"port_forwarding_guest_port"`
-		PortForwardingHostPort  int    `mapstructure:"port_forwarding_host_port"`
-		PortForwardingRuleName  string `mapstructure:"port_forwarding_rule_name"`
-	} `mapstructure:"port_forwarding_rules,omitempty"`
+	PortForwardingRules          []PortForwardingRule `mapstructure:"port_forwarding_rules,omitempty"`
+	PortForwardingRulesExclusive bool                 `mapstructure:"port_forwarding_rules_exclusive"`
+
+	NetworkCards []NetworkCard `mapstructure:"network_card"`
+
+	DisplayResolution string         `mapstructure:"display_resolution"`
+	Headless          config.Trilean `mapstructure:"headless"`
+	VNCPort           int            `mapstructure:"vnc_port"`
+	VNCBindAddress    string         `mapstructure:"vnc_bind_address"`
+	VNCPassword       string         `mapstructure:"vnc_password"`
+
+	HWUUID                   string            `mapstructure:"hw_uuid,omitempty"`
+	CustomVariables          map[string]string `mapstructure:"custom_variables"`
+	CustomVariablesExclusive bool              `mapstructure:"custom_variables_exclusive"`
 
-	HWUUID       string `mapstructure:"hw_uuid,omitempty"`
 	BootDelay    string `mapstructure:"boot_delay"`
 	EnableHtt    bool   `mapstructure:"enable_htt"`
 	DisableHtt   bool   `mapstructure:"disable_htt"`
 	UpdateAddons bool   `mapstructure:"update_addons"`
 	UseAnkaCP    bool   `mapstructure:"use_anka_cp"`
 
+	FileTransferMethod string `mapstructure:"file_transfer_method"`
+
+	GuestEnv map[string]string `mapstructure:"guest_env"`
+
+	GuestShell      string `mapstructure:"guest_shell"`
+	GuestLoginShell bool   `mapstructure:"guest_login_shell"`
+
+	RebootTimeout time.Duration `mapstructure:"reboot_timeout"`
+
+	RunAsUser    string `mapstructure:"run_as_user"`
+	UseSudo      bool   `mapstructure:"use_sudo"`
+	SudoPassword string `mapstructure:"sudo_password"`
+
+	// ExpectedMacOSVersion is a version constraint the guest's macOS must
+	// satisfy, like ">= 11.2".
+	ExpectedMacOSVersion string `mapstructure:"expected_macos_version"`
+
+	DestroyWithRunningClones bool   `mapstructure:"destroy_with_running_clones"`
+	DestroyPushedTemplate    bool   `mapstructure:"destroy_pushed_template"`
+	DestroyRegistryTemplate  bool   `mapstructure:"destroy_registry_template"`
+	DestroyRegistryTag       string `mapstructure:"destroy_registry_tag"`
+
+	AnkaAuditLog   string `mapstructure:"anka_audit_log"`
+	DiagnosticsDir string `mapstructure:"diagnostics_dir"`
+
+	OnErrorCollect OnErrorCollect `mapstructure:"on_error_collect"`
+
 	ctx interpolate.Context
 }
 
+// PortForwardingRule is a port-forwarding rule managed by the builder. Rules
+// are matched against the VM's existing rules by name.
+type PortForwardingRule struct {
+	PortForwardingGuestPort int    `mapstructure:"port_forwarding_guest_port"`
+	PortForwardingHostPort  int    `mapstructure:"port_forwarding_host_port"`
+	PortForwardingRuleName  string `mapstructure:"port_forwarding_rule_name"`
+	PortForwardingProtocol  string `mapstructure:"port_forwarding_protocol"`
+	PortForwardingHostIP    string `mapstructure:"port_forwarding_host_ip"`
+	PortForwardingNICIndex  int    `mapstructure:"port_forwarding_nic_index"`
+	// Temporary rules are removed once provisioning is done, before the VM
+	// is suspended.
+	PortForwardingTemporary bool `mapstructure:"port_forwarding_temporary"`
+}
+
+// NetworkCard configures one of the VM's network cards, identified by its
+// index. Cards that don't exist yet are added.
+type NetworkCard struct {
+	Index               int    `mapstructure:"index"`
+	Mode                string `mapstructure:"mode"`
+	MacAddress          string `mapstructure:"mac_address"`
+	RandomizeMacAddress bool   `mapstructure:"randomize_mac_address"`
+	Type                string `mapstructure:"type"`
+	// FinalMode is applied once provisioning is done, so the VM can be
+	// provisioned with one mode and shipped with another.
+	FinalMode string `mapstructure:"final_mode"`
+}
+
+// OnErrorCollect lists what to gather from the guest when the build fails.
+type OnErrorCollect struct {
+	Files    []string `mapstructure:"files"`
+	Commands []string `mapstructure:"commands"`
+}
+
 func NewConfig(raws ...interface{}) (*Config, error) {
 	var c Config
 
 	var md mapstructure.Metadata
 	err := config.Decode(&c, &config.DecodeOpts{
-		Metadata:    &md,
-		Interpolate: true,
+		Metadata:           &md,
+		Interpolate:        true,
+		InterpolateContext: &c.ctx,
+		InterpolateFilter: &interpolate.RenderFilter{
+			Exclude: []string{
+				"boot_command",
+				"base_vm_name",
+			},
+		},
 	}, raws...)
 	if err != nil {
 		return nil, err
@@ -63,21 +207,203 @@ func NewConfig(raws ...interface{}) (*Config, error) {
 	if c.Comm.Type == "" {
 		c.Comm.Type = "anka"
 	}
+	if c.Comm.Type == "ssh" {
+		for _, err := range c.Comm.Prepare(&c.ctx) {
+			errs = packer.MultiErrorAppend(errs, err)
+		}
+	}
 
-	if c.InstallerApp == "" && c.SourceVMName == "" {
-		errs = packer.MultiErrorAppend(errs, errors.New("installer_app or source_vm_name must be specified"))
+	for _, err := range c.VNCConfig.Prepare(&c.ctx) {
+		errs = packer.MultiErrorAppend(errs, err)
+	}
+
+	if c.InstallerVersion != "" {
+		if c.InstallerApp != "" {
+			errs = packer.MultiErrorAppend(errs, errors.New("installer_app and installer_version can't be used together"))
+		}
+		if _, err := version.NewConstraint(c.InstallerVersion); err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("installer_version %q is not a version constraint: %v", c.InstallerVersion, err))
+		}
+		if len(c.InstallerSearchPaths) == 0 {
+			c.InstallerSearchPaths = DEFAULT_INSTALLER_SEARCH_PATHS
+		}
+	} else if len(c.InstallerSearchPaths) > 0 {
+		errs = packer.MultiErrorAppend(errs, errors.New("installer_search_paths needs installer_version"))
+	}
+
+	if c.BaseVMMismatch == "" {
+		c.BaseVMMismatch = baseVMMismatchReuse
+	}
+	if _, ok := baseVMMismatchPolicies[c.BaseVMMismatch]; !ok {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("base_vm_mismatch %q must be one of reuse, rebuild or fail", c.BaseVMMismatch))
+	}
+	if c.InstallerApp == "" && c.InstallerVersion == "" {
+		if c.BaseVMName != "" || c.RebuildBaseVM || c.BaseVMMismatch != baseVMMismatchReuse {
+			errs = packer.MultiErrorAppend(errs, errors.New("base_vm_name, rebuild_base_vm and base_vm_mismatch need installer_app or installer_version"))
+		}
+	}
+	if c.BaseVMName != "" && c.SourceVMName != "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("base_vm_name and source_vm_name can't be used together"))
+	}
+
+	if c.InstallerApp == "" && c.InstallerVersion == "" && c.SourceVMName == "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("installer_app, installer_version or source_vm_name must be specified"))
 	}
 
 	// Handle Port Forwarding Rules
-	if len(c.PortForwardingRules) > 0 {
-		for index, rule := range c.PortForwardingRules {
-			if rule.PortForwardingGuestPort == 0 {
-				errs = packer.MultiErrorAppend(errs, errors.New("guest port is required"))
+	ruleNames := make(map[string]struct{}, len(c.PortForwardingRules))
+	hostPorts := make(map[string]string, len(c.PortForwardingRules))
+	for index := range c.PortForwardingRules {
+		rule := &c.PortForwardingRules[index]
+		if rule.PortForwardingGuestPort == 0 {
+			errs = packer.MultiErrorAppend(errs, errors.New("guest port is required"))
+		}
+		rule.PortForwardingProtocol = strings.ToLower(rule.PortForwardingProtocol)
+		switch rule.PortForwardingProtocol {
+		case "":
+			rule.PortForwardingProtocol = "tcp"
+		case "tcp", "udp":
+		default:
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("port forwarding protocol %q must be tcp or udp", rule.PortForwardingProtocol))
+		}
+		if rule.PortForwardingNICIndex < 0 {
+			errs = packer.MultiErrorAppend(errs, errors.New("port forwarding nic index can't be negative"))
+		}
+		if rule.PortForwardingRuleName == "" { // Stable name so the rule is recognized on the next build
+			rule.PortForwardingRuleName = fmt.Sprintf("packer-%s-%d", rule.PortForwardingProtocol, rule.PortForwardingGuestPort)
+		}
+		if _, ok := ruleNames[rule.PortForwardingRuleName]; ok {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("duplicate port forwarding rule name %q", rule.PortForwardingRuleName))
+		}
+		ruleNames[rule.PortForwardingRuleName] = struct{}{}
+		if rule.PortForwardingHostPort != 0 {
+			key := hostPortKey(rule.PortForwardingProtocol, rule.PortForwardingHostPort)
+			if owner, ok := hostPorts[key]; ok {
+				errs = packer.MultiErrorAppend(errs, fmt.Errorf("port forwarding rules %q and %q use the same host port %d (%s)",
+					owner, rule.PortForwardingRuleName, rule.PortForwardingHostPort, rule.PortForwardingProtocol))
 			}
-			if rule.PortForwardingRuleName == "" {
-				c.PortForwardingRules[index].PortForwardingRuleName = fmt.Sprintf("%s", randSeq(10))
+			hostPorts[key] = rule.PortForwardingRuleName
+		}
+	}
+
+	// hw_uuid is shorthand for the hw.UUID custom variable
+	if c.HWUUID != "" {
+		if c.CustomVariables == nil {
+			c.CustomVariables = map[string]string{}
+		}
+		if existing, ok := c.CustomVariables["hw.UUID"]; ok && existing != c.HWUUID {
+			errs = packer.MultiErrorAppend(errs, errors.New("hw_uuid and custom_variables hw.UUID are both set and differ"))
+		}
+		c.CustomVariables["hw.UUID"] = c.HWUUID
+	}
+
+	nicIndexes := make(map[int]struct{}, len(c.NetworkCards))
+	for index := range c.NetworkCards {
+		nic := &c.NetworkCards[index]
+		if nic.Index < 0 {
+			errs = packer.MultiErrorAppend(errs, errors.New("network_card index can't be negative"))
+		}
+		if _, ok := nicIndexes[nic.Index]; ok {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("network_card index %d is configured more than once", nic.Index))
+		}
+		nicIndexes[nic.Index] = struct{}{}
+		nic.Mode = strings.ToLower(nic.Mode)
+		nic.FinalMode = strings.ToLower(nic.FinalMode)
+		for _, mode := range []string{nic.Mode, nic.FinalMode} {
+			if _, ok := networkModes[mode]; mode != "" && !ok {
+				errs = packer.MultiErrorAppend(errs, fmt.Errorf("network_card mode %q must be one of shared, host, bridged or disconnected", mode))
+			}
+		}
+		if nic.Type != "" {
+			if _, ok := networkCardTypes[nic.Type]; !ok {
+				errs = packer.MultiErrorAppend(errs, fmt.Errorf("network_card type %q must be virtio-net or e1000", nic.Type))
 			}
 		}
+		if nic.MacAddress != "" {
+			if nic.RandomizeMacAddress {
+				errs = packer.MultiErrorAppend(errs, errors.New("network_card mac_address and randomize_mac_address can't both be set"))
+			}
+			if _, err := net.ParseMAC(nic.MacAddress); err != nil {
+				errs = packer.MultiErrorAppend(errs, fmt.Errorf("network_card mac_address is invalid: %w", err))
+			}
+		}
+	}
+
+	if c.DisplayResolution != "" {
+		if _, _, err := parseResolution(c.DisplayResolution); err != nil {
+			errs = packer.MultiErrorAppend(errs, err)
+		}
+	}
+	if c.VNCPort < 0 || c.VNCPort > 65535 {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("vnc_port %d is not a valid port", c.VNCPort))
+	}
+	if c.VNCPassword != "" {
+		packer.LogSecretFilter.Set(c.VNCPassword)
+	}
+	if c.VNCBindAddress != "" && net.ParseIP(c.VNCBindAddress) == nil {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("vnc_bind_address %q is not an IP address", c.VNCBindAddress))
+	}
+
+	if !c.OnErrorCollect.empty() && c.DiagnosticsDir == "" {
+		errs = packer.MultiErrorAppend(errs, errors.New("diagnostics_dir must be set to use on_error_collect"))
+	}
+
+	// use_anka_cp predates file_transfer_method
+	if c.UseAnkaCP {
+		if c.FileTransferMethod != "" && c.FileTransferMethod != fileTransferAnkaCP {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("use_anka_cp can't be combined with file_transfer_method %q", c.FileTransferMethod))
+		}
+		c.FileTransferMethod = fileTransferAnkaCP
+	}
+	if c.FileTransferMethod == "" {
+		c.FileTransferMethod = fileTransferAuto
+	}
+	if _, ok := fileTransferMethods[c.FileTransferMethod]; !ok {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("file_transfer_method %q must be one of auto, shared_volume, anka_cp or tar", c.FileTransferMethod))
+	}
+
+	if c.SudoPassword != "" {
+		if c.RunAsUser == "" && !c.UseSudo {
+			errs = packer.MultiErrorAppend(errs, errors.New("sudo_password needs use_sudo or run_as_user"))
+		}
+		packer.LogSecretFilter.Set(c.SudoPassword)
+	}
+
+	if c.ExpectedMacOSVersion != "" {
+		if _, err := version.NewConstraint(c.ExpectedMacOSVersion); err != nil {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("expected_macos_version %q is not a version constraint: %v", c.ExpectedMacOSVersion, err))
+		}
+	}
+
+	if c.DestroyRegistryTag != "" && !c.DestroyRegistryTemplate {
+		errs = packer.MultiErrorAppend(errs, errors.New("destroy_registry_tag needs destroy_registry_template"))
+	}
+
+	if c.LockTimeout == 0 {
+		c.LockTimeout = DEFAULT_LOCK_TIMEOUT
+	}
+
+	if c.RebootTimeout == 0 {
+		c.RebootTimeout = DEFAULT_REBOOT_TIMEOUT
+	}
+
+	if c.GuestShell == "" {
+		c.GuestShell = "sh"
+	}
+	if _, ok := guestShells[c.GuestShell]; !ok && !path.IsAbs(c.GuestShell) {
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("guest_shell %q must be sh, bash, zsh or an absolute path", c.GuestShell))
+	}
+
+	for key := range c.GuestEnv {
+		if !envVariableName.MatchString(key) {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("guest_env name %q is not a valid environment variable name", key))
+		}
+	}
+
+	for key := range c.CustomVariables {
+		if _, ok := knownCustomVariables[key]; !ok {
+			errs = packer.MultiErrorAppend(errs, fmt.Errorf("unknown custom variable %q, must be one of: %s", key, strings.Join(knownCustomVariableNames(), ", ")))
+		}
 	}
 
 	if strings.ContainsAny(c.SourceVMName, " \n") {
@@ -1,4 +1,4 @@
-// Code generated by "mapstructure-to-hcl2 -type Config"; DO NOT EDIT.
+// Code generated by "mapstructure-to-hcl2 -type Config,PortForwardingRule,NetworkCard,OnErrorCollect"; DO NOT EDIT.
 
 package anka
 
@@ -10,80 +10,112 @@ import (
 // FlatConfig is an auto-generated flat version of Config.
 // Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
 type FlatConfig struct {
-	PackerBuildName           *string           `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
-	PackerBuilderType         *string           `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
-	PackerCoreVersion         *string           `mapstructure:"packer_core_version" cty:"packer_core_version" hcl:"packer_core_version"`
-	PackerDebug               *bool             `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
-	PackerForce               *bool             `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
-	PackerOnError             *string           `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
-	PackerUserVars            map[string]string `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
-	PackerSensitiveVars       []string          `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
-	Type                      *string           `mapstructure:"communicator" cty:"communicator" hcl:"communicator"`
-	PauseBeforeConnect        *string           `mapstructure:"pause_before_connecting" cty:"pause_before_connecting" hcl:"pause_before_connecting"`
-	SSHHost                   *string           `mapstructure:"ssh_host" cty:"ssh_host" hcl:"ssh_host"`
-	SSHPort                   *int              `mapstructure:"ssh_port" cty:"ssh_port" hcl:"ssh_port"`
-	SSHUsername               *string           `mapstructure:"ssh_username" cty:"ssh_username" hcl:"ssh_username"`
-	SSHPassword               *string           `mapstructure:"ssh_password" cty:"ssh_password" hcl:"ssh_password"`
-	SSHKeyPairName            *string           `mapstructure:"ssh_keypair_name" undocumented:"true" cty:"ssh_keypair_name" hcl:"ssh_keypair_name"`
-	SSHTemporaryKeyPairName   *string           `mapstructure:"temporary_key_pair_name" undocumented:"true" cty:"temporary_key_pair_name" hcl:"temporary_key_pair_name"`
-	SSHTemporaryKeyPairType   *string           `mapstructure:"temporary_key_pair_type" cty:"temporary_key_pair_type" hcl:"temporary_key_pair_type"`
-	SSHTemporaryKeyPairBits   *int              `mapstructure:"temporary_key_pair_bits" cty:"temporary_key_pair_bits" hcl:"temporary_key_pair_bits"`
-	SSHCiphers                []string          `mapstructure:"ssh_ciphers" cty:"ssh_ciphers" hcl:"ssh_ciphers"`
-	SSHClearAuthorizedKeys    *bool             `mapstructure:"ssh_clear_authorized_keys" cty:"ssh_clear_authorized_keys" hcl:"ssh_clear_authorized_keys"`
-	SSHKEXAlgos               []string          `mapstructure:"ssh_key_exchange_algorithms" cty:"ssh_key_exchange_algorithms" hcl:"ssh_key_exchange_algorithms"`
-	SSHPrivateKeyFile         *string           `mapstructure:"ssh_private_key_file" undocumented:"true" cty:"ssh_private_key_file" hcl:"ssh_private_key_file"`
-	SSHCertificateFile        *string           `mapstructure:"ssh_certificate_file" cty:"ssh_certificate_file" hcl:"ssh_certificate_file"`
-	SSHPty                    *bool             `mapstructure:"ssh_pty" cty:"ssh_pty" hcl:"ssh_pty"`
-	SSHTimeout                *string           `mapstructure:"ssh_timeout" cty:"ssh_timeout" hcl:"ssh_timeout"`
-	SSHWaitTimeout            *string           `mapstructure:"ssh_wait_timeout" undocumented:"true" cty:"ssh_wait_timeout" hcl:"ssh_wait_timeout"`
-	SSHAgentAuth              *bool             `mapstructure:"ssh_agent_auth" undocumented:"true" cty:"ssh_agent_auth" hcl:"ssh_agent_auth"`
-	SSHDisableAgentForwarding *bool             `mapstructure:"ssh_disable_agent_forwarding" cty:"ssh_disable_agent_forwarding" hcl:"ssh_disable_agent_forwarding"`
-	SSHHandshakeAttempts      *int              `mapstructure:"ssh_handshake_attempts" cty:"ssh_handshake_attempts" hcl:"ssh_handshake_attempts"`
-	SSHBastionHost            *string           `mapstructure:"ssh_bastion_host" cty:"ssh_bastion_host" hcl:"ssh_bastion_host"`
-	SSHBastionPort            *int              `mapstructure:"ssh_bastion_port" cty:"ssh_bastion_port" hcl:"ssh_bastion_port"`
-	SSHBastionAgentAuth       *bool             `mapstructure:"ssh_bastion_agent_auth" cty:"ssh_bastion_agent_auth" hcl:"ssh_bastion_agent_auth"`
-	SSHBastionUsername        *string           `mapstructure:"ssh_bastion_username" cty:"ssh_bastion_username" hcl:"ssh_bastion_username"`
-	SSHBastionPassword        *string           `mapstructure:"ssh_bastion_password" cty:"ssh_bastion_password" hcl:"ssh_bastion_password"`
-	SSHBastionInteractive     *bool             `mapstructure:"ssh_bastion_interactive" cty:"ssh_bastion_interactive" hcl:"ssh_bastion_interactive"`
-	SSHBastionPrivateKeyFile  *string           `mapstructure:"ssh_bastion_private_key_file" cty:"ssh_bastion_private_key_file" hcl:"ssh_bastion_private_key_file"`
-	SSHBastionCertificateFile *string           `mapstructure:"ssh_bastion_certificate_file" cty:"ssh_bastion_certificate_file" hcl:"ssh_bastion_certificate_file"`
-	SSHFileTransferMethod     *string           `mapstructure:"ssh_file_transfer_method" cty:"ssh_file_transfer_method" hcl:"ssh_file_transfer_method"`
-	SSHProxyHost              *string           `mapstructure:"ssh_proxy_host" cty:"ssh_proxy_host" hcl:"ssh_proxy_host"`
-	SSHProxyPort              *int              `mapstructure:"ssh_proxy_port" cty:"ssh_proxy_port" hcl:"ssh_proxy_port"`
-	SSHProxyUsername          *string           `mapstructure:"ssh_proxy_username" cty:"ssh_proxy_username" hcl:"ssh_proxy_username"`
-	SSHProxyPassword          *string           `mapstructure:"ssh_proxy_password" cty:"ssh_proxy_password" hcl:"ssh_proxy_password"`
-	SSHKeepAliveInterval      *string           `mapstructure:"ssh_keep_alive_interval" cty:"ssh_keep_alive_interval" hcl:"ssh_keep_alive_interval"`
-	SSHReadWriteTimeout       *string           `mapstructure:"ssh_read_write_timeout" cty:"ssh_read_write_timeout" hcl:"ssh_read_write_timeout"`
-	SSHRemoteTunnels          []string          `mapstructure:"ssh_remote_tunnels" cty:"ssh_remote_tunnels" hcl:"ssh_remote_tunnels"`
-	SSHLocalTunnels           []string          `mapstructure:"ssh_local_tunnels" cty:"ssh_local_tunnels" hcl:"ssh_local_tunnels"`
-	SSHPublicKey              []byte            `mapstructure:"ssh_public_key" undocumented:"true" cty:"ssh_public_key" hcl:"ssh_public_key"`
-	SSHPrivateKey             []byte            `mapstructure:"ssh_private_key" undocumented:"true" cty:"ssh_private_key" hcl:"ssh_private_key"`
-	WinRMUser                 *string           `mapstructure:"winrm_username" cty:"winrm_username" hcl:"winrm_username"`
-	WinRMPassword             *string           `mapstructure:"winrm_password" cty:"winrm_password" hcl:"winrm_password"`
-	WinRMHost                 *string           `mapstructure:"winrm_host" cty:"winrm_host" hcl:"winrm_host"`
-	WinRMNoProxy              *bool             `mapstructure:"winrm_no_proxy" cty:"winrm_no_proxy" hcl:"winrm_no_proxy"`
-	WinRMPort                 *int              `mapstructure:"winrm_port" cty:"winrm_port" hcl:"winrm_port"`
-	WinRMTimeout              *string           `mapstructure:"winrm_timeout" cty:"winrm_timeout" hcl:"winrm_timeout"`
-	WinRMUseSSL               *bool             `mapstructure:"winrm_use_ssl" cty:"winrm_use_ssl" hcl:"winrm_use_ssl"`
-	WinRMInsecure             *bool             `mapstructure:"winrm_insecure" cty:"winrm_insecure" hcl:"winrm_insecure"`
-	WinRMUseNTLM              *bool             `mapstructure:"winrm_use_ntlm" cty:"winrm_use_ntlm" hcl:"winrm_use_ntlm"`
-	InstallerApp              *string           `mapstructure:"installer_app" cty:"installer_app" hcl:"installer_app"`
-	SourceVMName              *string           `mapstructure:"source_vm_name" cty:"source_vm_name" hcl:"source_vm_name"`
-	VMName                    *string           `mapstructure:"vm_name" cty:"vm_name" hcl:"vm_name"`
-	DiskSize                  *string           `mapstructure:"disk_size" cty:"disk_size" hcl:"disk_size"`
-	RAMSize                   *string           `mapstructure:"ram_size" cty:"ram_size" hcl:"ram_size"`
-	CPUCount                  *string           `mapstructure:"cpu_count" cty:"cpu_count" hcl:"cpu_count"`
-	PortForwardingRules       []struct {
-		PortForwardingGuestPort int    "mapstructure:\"port_forwarding_guest_port\""
-		PortForwardingHostPort  int    "mapstructure:\"port_forwarding_host_port\""
-		PortForwardingRuleName  string "mapstructure:\"port_forwarding_rule_name\""
-	} `mapstructure:"port_forwarding_rules,omitempty" cty:"port_forwarding_rules" hcl:"port_forwarding_rules"`
-	HWUUID       *string `mapstructure:"hw_uuid,omitempty" cty:"hw_uuid" hcl:"hw_uuid"`
-	BootDelay    *string `mapstructure:"boot_delay" cty:"boot_delay" hcl:"boot_delay"`
-	EnableHtt    *bool   `mapstructure:"enable_htt" cty:"enable_htt" hcl:"enable_htt"`
-	DisableHtt   *bool   `mapstructure:"disable_htt" cty:"disable_htt" hcl:"disable_htt"`
-	UpdateAddons *bool   `mapstructure:"update_addons" cty:"update_addons" hcl:"update_addons"`
-	UseAnkaCP    *bool   `mapstructure:"use_anka_cp" cty:"use_anka_cp" hcl:"use_anka_cp"`
+	PackerBuildName              *string                  `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
+	PackerBuilderType            *string                  `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
+	PackerCoreVersion            *string                  `mapstructure:"packer_core_version" cty:"packer_core_version" hcl:"packer_core_version"`
+	PackerDebug                  *bool                    `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
+	PackerForce                  *bool                    `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
+	PackerOnError                *string                  `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
+	PackerUserVars               map[string]string        `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
+	PackerSensitiveVars          []string                 `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
+	Type                         *string                  `mapstructure:"communicator" cty:"communicator" hcl:"communicator"`
+	PauseBeforeConnect           *string                  `mapstructure:"pause_before_connecting" cty:"pause_before_connecting" hcl:"pause_before_connecting"`
+	SSHHost                      *string                  `mapstructure:"ssh_host" cty:"ssh_host" hcl:"ssh_host"`
+	SSHPort                      *int                     `mapstructure:"ssh_port" cty:"ssh_port" hcl:"ssh_port"`
+	SSHUsername                  *string                  `mapstructure:"ssh_username" cty:"ssh_username" hcl:"ssh_username"`
+	SSHPassword                  *string                  `mapstructure:"ssh_password" cty:"ssh_password" hcl:"ssh_password"`
+	SSHKeyPairName               *string                  `mapstructure:"ssh_keypair_name" undocumented:"true" cty:"ssh_keypair_name" hcl:"ssh_keypair_name"`
+	SSHTemporaryKeyPairName      *string                  `mapstructure:"temporary_key_pair_name" undocumented:"true" cty:"temporary_key_pair_name" hcl:"temporary_key_pair_name"`
+	SSHTemporaryKeyPairType      *string                  `mapstructure:"temporary_key_pair_type" cty:"temporary_key_pair_type" hcl:"temporary_key_pair_type"`
+	SSHTemporaryKeyPairBits      *int                     `mapstructure:"temporary_key_pair_bits" cty:"temporary_key_pair_bits" hcl:"temporary_key_pair_bits"`
+	SSHCiphers                   []string                 `mapstructure:"ssh_ciphers" cty:"ssh_ciphers" hcl:"ssh_ciphers"`
+	SSHClearAuthorizedKeys       *bool                    `mapstructure:"ssh_clear_authorized_keys" cty:"ssh_clear_authorized_keys" hcl:"ssh_clear_authorized_keys"`
+	SSHKEXAlgos                  []string                 `mapstructure:"ssh_key_exchange_algorithms" cty:"ssh_key_exchange_algorithms" hcl:"ssh_key_exchange_algorithms"`
+	SSHPrivateKeyFile            *string                  `mapstructure:"ssh_private_key_file" undocumented:"true" cty:"ssh_private_key_file" hcl:"ssh_private_key_file"`
+	SSHCertificateFile           *string                  `mapstructure:"ssh_certificate_file" cty:"ssh_certificate_file" hcl:"ssh_certificate_file"`
+	SSHPty                       *bool                    `mapstructure:"ssh_pty" cty:"ssh_pty" hcl:"ssh_pty"`
+	SSHTimeout                   *string                  `mapstructure:"ssh_timeout" cty:"ssh_timeout" hcl:"ssh_timeout"`
+	SSHWaitTimeout               *string                  `mapstructure:"ssh_wait_timeout" undocumented:"true" cty:"ssh_wait_timeout" hcl:"ssh_wait_timeout"`
+	SSHAgentAuth                 *bool                    `mapstructure:"ssh_agent_auth" undocumented:"true" cty:"ssh_agent_auth" hcl:"ssh_agent_auth"`
+	SSHDisableAgentForwarding    *bool                    `mapstructure:"ssh_disable_agent_forwarding" cty:"ssh_disable_agent_forwarding" hcl:"ssh_disable_agent_forwarding"`
+	SSHHandshakeAttempts         *int                     `mapstructure:"ssh_handshake_attempts" cty:"ssh_handshake_attempts" hcl:"ssh_handshake_attempts"`
+	SSHBastionHost               *string                  `mapstructure:"ssh_bastion_host" cty:"ssh_bastion_host" hcl:"ssh_bastion_host"`
+	SSHBastionPort               *int                     `mapstructure:"ssh_bastion_port" cty:"ssh_bastion_port" hcl:"ssh_bastion_port"`
+	SSHBastionAgentAuth          *bool                    `mapstructure:"ssh_bastion_agent_auth" cty:"ssh_bastion_agent_auth" hcl:"ssh_bastion_agent_auth"`
+	SSHBastionUsername           *string                  `mapstructure:"ssh_bastion_username" cty:"ssh_bastion_username" hcl:"ssh_bastion_username"`
+	SSHBastionPassword           *string                  `mapstructure:"ssh_bastion_password" cty:"ssh_bastion_password" hcl:"ssh_bastion_password"`
+	SSHBastionInteractive        *bool                    `mapstructure:"ssh_bastion_interactive" cty:"ssh_bastion_interactive" hcl:"ssh_bastion_interactive"`
+	SSHBastionPrivateKeyFile     *string                  `mapstructure:"ssh_bastion_private_key_file" cty:"ssh_bastion_private_key_file" hcl:"ssh_bastion_private_key_file"`
+	SSHBastionCertificateFile    *string                  `mapstructure:"ssh_bastion_certificate_file" cty:"ssh_bastion_certificate_file" hcl:"ssh_bastion_certificate_file"`
+	SSHFileTransferMethod        *string                  `mapstructure:"ssh_file_transfer_method" cty:"ssh_file_transfer_method" hcl:"ssh_file_transfer_method"`
+	SSHProxyHost                 *string                  `mapstructure:"ssh_proxy_host" cty:"ssh_proxy_host" hcl:"ssh_proxy_host"`
+	SSHProxyPort                 *int                     `mapstructure:"ssh_proxy_port" cty:"ssh_proxy_port" hcl:"ssh_proxy_port"`
+	SSHProxyUsername             *string                  `mapstructure:"ssh_proxy_username" cty:"ssh_proxy_username" hcl:"ssh_proxy_username"`
+	SSHProxyPassword             *string                  `mapstructure:"ssh_proxy_password" cty:"ssh_proxy_password" hcl:"ssh_proxy_password"`
+	SSHKeepAliveInterval         *string                  `mapstructure:"ssh_keep_alive_interval" cty:"ssh_keep_alive_interval" hcl:"ssh_keep_alive_interval"`
+	SSHReadWriteTimeout          *string                  `mapstructure:"ssh_read_write_timeout" cty:"ssh_read_write_timeout" hcl:"ssh_read_write_timeout"`
+	SSHRemoteTunnels             []string                 `mapstructure:"ssh_remote_tunnels" cty:"ssh_remote_tunnels" hcl:"ssh_remote_tunnels"`
+	SSHLocalTunnels              []string                 `mapstructure:"ssh_local_tunnels" cty:"ssh_local_tunnels" hcl:"ssh_local_tunnels"`
+	SSHPublicKey                 []byte                   `mapstructure:"ssh_public_key" undocumented:"true" cty:"ssh_public_key" hcl:"ssh_public_key"`
+	SSHPrivateKey                []byte                   `mapstructure:"ssh_private_key" undocumented:"true" cty:"ssh_private_key" hcl:"ssh_private_key"`
+	WinRMUser                    *string                  `mapstructure:"winrm_username" cty:"winrm_username" hcl:"winrm_username"`
+	WinRMPassword                *string                  `mapstructure:"winrm_password" cty:"winrm_password" hcl:"winrm_password"`
+	WinRMHost                    *string                  `mapstructure:"winrm_host" cty:"winrm_host" hcl:"winrm_host"`
+	WinRMNoProxy                 *bool                    `mapstructure:"winrm_no_proxy" cty:"winrm_no_proxy" hcl:"winrm_no_proxy"`
+	WinRMPort                    *int                     `mapstructure:"winrm_port" cty:"winrm_port" hcl:"winrm_port"`
+	WinRMTimeout                 *string                  `mapstructure:"winrm_timeout" cty:"winrm_timeout" hcl:"winrm_timeout"`
+	WinRMUseSSL                  *bool                    `mapstructure:"winrm_use_ssl" cty:"winrm_use_ssl" hcl:"winrm_use_ssl"`
+	WinRMInsecure                *bool                    `mapstructure:"winrm_insecure" cty:"winrm_insecure" hcl:"winrm_insecure"`
+	WinRMUseNTLM                 *bool                    `mapstructure:"winrm_use_ntlm" cty:"winrm_use_ntlm" hcl:"winrm_use_ntlm"`
+	BootGroupInterval            *string                  `mapstructure:"boot_keygroup_interval" cty:"boot_keygroup_interval" hcl:"boot_keygroup_interval"`
+	BootWait                     *string                  `mapstructure:"boot_wait" cty:"boot_wait" hcl:"boot_wait"`
+	BootCommand                  []string                 `mapstructure:"boot_command" cty:"boot_command" hcl:"boot_command"`
+	DisableVNC                   *bool                    `mapstructure:"disable_vnc" cty:"disable_vnc" hcl:"disable_vnc"`
+	BootKeyInterval              *string                  `mapstructure:"boot_key_interval" cty:"boot_key_interval" hcl:"boot_key_interval"`
+	InstallerApp                 *string                  `mapstructure:"installer_app" cty:"installer_app" hcl:"installer_app"`
+	InstallerVersion             *string                  `mapstructure:"installer_version" cty:"installer_version" hcl:"installer_version"`
+	InstallerSearchPaths         []string                 `mapstructure:"installer_search_paths" cty:"installer_search_paths" hcl:"installer_search_paths"`
+	BaseVMName                   *string                  `mapstructure:"base_vm_name" cty:"base_vm_name" hcl:"base_vm_name"`
+	RebuildBaseVM                *bool                    `mapstructure:"rebuild_base_vm" cty:"rebuild_base_vm" hcl:"rebuild_base_vm"`
+	BaseVMMismatch               *string                  `mapstructure:"base_vm_mismatch" cty:"base_vm_mismatch" hcl:"base_vm_mismatch"`
+	LockTimeout                  *string                  `mapstructure:"lock_timeout" cty:"lock_timeout" hcl:"lock_timeout"`
+	SourceVMName                 *string                  `mapstructure:"source_vm_name" cty:"source_vm_name" hcl:"source_vm_name"`
+	VMName                       *string                  `mapstructure:"vm_name" cty:"vm_name" hcl:"vm_name"`
+	DiskSize                     *string                  `mapstructure:"disk_size" cty:"disk_size" hcl:"disk_size"`
+	RAMSize                      *string                  `mapstructure:"ram_size" cty:"ram_size" hcl:"ram_size"`
+	CPUCount                     *string                  `mapstructure:"cpu_count" cty:"cpu_count" hcl:"cpu_count"`
+	PortForwardingRules          []FlatPortForwardingRule `mapstructure:"port_forwarding_rules,omitempty" cty:"port_forwarding_rules" hcl:"port_forwarding_rules"`
+	PortForwardingRulesExclusive *bool                    `mapstructure:"port_forwarding_rules_exclusive" cty:"port_forwarding_rules_exclusive" hcl:"port_forwarding_rules_exclusive"`
+	NetworkCards                 []FlatNetworkCard        `mapstructure:"network_card" cty:"network_card" hcl:"network_card"`
+	DisplayResolution            *string                  `mapstructure:"display_resolution" cty:"display_resolution" hcl:"display_resolution"`
+	Headless                     *bool                    `mapstructure:"headless" cty:"headless" hcl:"headless"`
+	VNCPort                      *int                     `mapstructure:"vnc_port" cty:"vnc_port" hcl:"vnc_port"`
+	VNCBindAddress               *string                  `mapstructure:"vnc_bind_address" cty:"vnc_bind_address" hcl:"vnc_bind_address"`
+	VNCPassword                  *string                  `mapstructure:"vnc_password" cty:"vnc_password" hcl:"vnc_password"`
+	HWUUID                       *string                  `mapstructure:"hw_uuid,omitempty" cty:"hw_uuid" hcl:"hw_uuid"`
+	CustomVariables              map[string]string        `mapstructure:"custom_variables" cty:"custom_variables" hcl:"custom_variables"`
+	CustomVariablesExclusive     *bool                    `mapstructure:"custom_variables_exclusive" cty:"custom_variables_exclusive" hcl:"custom_variables_exclusive"`
+	BootDelay                    *string                  `mapstructure:"boot_delay" cty:"boot_delay" hcl:"boot_delay"`
+	EnableHtt                    *bool                    `mapstructure:"enable_htt" cty:"enable_htt" hcl:"enable_htt"`
+	DisableHtt                   *bool                    `mapstructure:"disable_htt" cty:"disable_htt" hcl:"disable_htt"`
+	UpdateAddons                 *bool                    `mapstructure:"update_addons" cty:"update_addons" hcl:"update_addons"`
+	UseAnkaCP                    *bool                    `mapstructure:"use_anka_cp" cty:"use_anka_cp" hcl:"use_anka_cp"`
+	FileTransferMethod           *string                  `mapstructure:"file_transfer_method" cty:"file_transfer_method" hcl:"file_transfer_method"`
+	GuestEnv                     map[string]string        `mapstructure:"guest_env" cty:"guest_env" hcl:"guest_env"`
+	GuestShell                   *string                  `mapstructure:"guest_shell" cty:"guest_shell" hcl:"guest_shell"`
+	GuestLoginShell              *bool                    `mapstructure:"guest_login_shell" cty:"guest_login_shell" hcl:"guest_login_shell"`
+	RebootTimeout                *string                  `mapstructure:"reboot_timeout" cty:"reboot_timeout" hcl:"reboot_timeout"`
+	RunAsUser                    *string                  `mapstructure:"run_as_user" cty:"run_as_user" hcl:"run_as_user"`
+	UseSudo                      *bool                    `mapstructure:"use_sudo" cty:"use_sudo" hcl:"use_sudo"`
+	SudoPassword                 *string                  `mapstructure:"sudo_password" cty:"sudo_password" hcl:"sudo_password"`
+	ExpectedMacOSVersion         *string                  `mapstructure:"expected_macos_version" cty:"expected_macos_version" hcl:"expected_macos_version"`
+	DestroyWithRunningClones     *bool                    `mapstructure:"destroy_with_running_clones" cty:"destroy_with_running_clones" hcl:"destroy_with_running_clones"`
+	DestroyPushedTemplate        *bool                    `mapstructure:"destroy_pushed_template" cty:"destroy_pushed_template" hcl:"destroy_pushed_template"`
+	DestroyRegistryTemplate      *bool                    `mapstructure:"destroy_registry_template" cty:"destroy_registry_template" hcl:"destroy_registry_template"`
+	DestroyRegistryTag           *string                  `mapstructure:"destroy_registry_tag" cty:"destroy_registry_tag" hcl:"destroy_registry_tag"`
+	AnkaAuditLog                 *string                  `mapstructure:"anka_audit_log" cty:"anka_audit_log" hcl:"anka_audit_log"`
+	DiagnosticsDir               *string                  `mapstructure:"diagnostics_dir" cty:"diagnostics_dir" hcl:"diagnostics_dir"`
+	OnErrorCollect               *FlatOnErrorCollect      `mapstructure:"on_error_collect" cty:"on_error_collect" hcl:"on_error_collect"`
 }
 
 // FlatMapstructure returns a new FlatConfig.
@@ -98,76 +130,205 @@ func (*Config) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec }
 // The decoded values from this spec will then be applied to a FlatConfig.
 func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 	s := map[string]hcldec.Spec{
-		"packer_build_name":            &hcldec.AttrSpec{Name: "packer_build_name", Type: cty.String, Required: false},
-		"packer_builder_type":          &hcldec.AttrSpec{Name: "packer_builder_type", Type: cty.String, Required: false},
-		"packer_core_version":          &hcldec.AttrSpec{Name: "packer_core_version", Type: cty.String, Required: false},
-		"packer_debug":                 &hcldec.AttrSpec{Name: "packer_debug", Type: cty.Bool, Required: false},
-		"packer_force":                 &hcldec.AttrSpec{Name: "packer_force", Type: cty.Bool, Required: false},
-		"packer_on_error":              &hcldec.AttrSpec{Name: "packer_on_error", Type: cty.String, Required: false},
-		"packer_user_variables":        &hcldec.AttrSpec{Name: "packer_user_variables", Type: cty.Map(cty.String), Required: false},
-		"packer_sensitive_variables":   &hcldec.AttrSpec{Name: "packer_sensitive_variables", Type: cty.List(cty.String), Required: false},
-		"communicator":                 &hcldec.AttrSpec{Name: "communicator", Type: cty.String, Required: false},
-		"pause_before_connecting":      &hcldec.AttrSpec{Name: "pause_before_connecting", Type: cty.String, Required: false},
-		"ssh_host":                     &hcldec.AttrSpec{Name: "ssh_host", Type: cty.String, Required: false},
-		"ssh_port":                     &hcldec.AttrSpec{Name: "ssh_port", Type: cty.Number, Required: false},
-		"ssh_username":                 &hcldec.AttrSpec{Name: "ssh_username", Type: cty.String, Required: false},
-		"ssh_password":                 &hcldec.AttrSpec{Name: "ssh_password", Type: cty.String, Required: false},
-		"ssh_keypair_name":             &hcldec.AttrSpec{Name: "ssh_keypair_name", Type: cty.String, Required: false},
-		"temporary_key_pair_name":      &hcldec.AttrSpec{Name: "temporary_key_pair_name", Type: cty.String, Required: false},
-		"temporary_key_pair_type":      &hcldec.AttrSpec{Name: "temporary_key_pair_type", Type: cty.String, Required: false},
-		"temporary_key_pair_bits":      &hcldec.AttrSpec{Name: "temporary_key_pair_bits", Type: cty.Number, Required: false},
-		"ssh_ciphers":                  &hcldec.AttrSpec{Name: "ssh_ciphers", Type: cty.List(cty.String), Required: false},
-		"ssh_clear_authorized_keys":    &hcldec.AttrSpec{Name: "ssh_clear_authorized_keys", Type: cty.Bool, Required: false},
-		"ssh_key_exchange_algorithms":  &hcldec.AttrSpec{Name: "ssh_key_exchange_algorithms", Type: cty.List(cty.String), Required: false},
-		"ssh_private_key_file":         &hcldec.AttrSpec{Name: "ssh_private_key_file", Type: cty.String, Required: false},
-		"ssh_certificate_file":         &hcldec.AttrSpec{Name: "ssh_certificate_file", Type: cty.String, Required: false},
-		"ssh_pty":                      &hcldec.AttrSpec{Name: "ssh_pty", Type: cty.Bool, Required: false},
-		"ssh_timeout":                  &hcldec.AttrSpec{Name: "ssh_timeout", Type: cty.String, Required: false},
-		"ssh_wait_timeout":             &hcldec.AttrSpec{Name: "ssh_wait_timeout", Type: cty.String, Required: false},
-		"ssh_agent_auth":               &hcldec.AttrSpec{Name: "ssh_agent_auth", Type: cty.Bool, Required: false},
-		"ssh_disable_agent_forwarding": &hcldec.AttrSpec{Name: "ssh_disable_agent_forwarding", Type: cty.Bool, Required: false},
-		"ssh_handshake_attempts":       &hcldec.AttrSpec{Name: "ssh_handshake_attempts", Type: cty.Number, Required: false},
-		"ssh_bastion_host":             &hcldec.AttrSpec{Name: "ssh_bastion_host", Type: cty.String, Required: false},
-		"ssh_bastion_port":             &hcldec.AttrSpec{Name: "ssh_bastion_port", Type: cty.Number, Required: false},
-		"ssh_bastion_agent_auth":       &hcldec.AttrSpec{Name: "ssh_bastion_agent_auth", Type: cty.Bool, Required: false},
-		"ssh_bastion_username":         &hcldec.AttrSpec{Name: "ssh_bastion_username", Type: cty.String, Required: false},
-		"ssh_bastion_password":         &hcldec.AttrSpec{Name: "ssh_bastion_password", Type: cty.String, Required: false},
-		"ssh_bastion_interactive":      &hcldec.AttrSpec{Name: "ssh_bastion_interactive", Type: cty.Bool, Required: false},
-		"ssh_bastion_private_key_file": &hcldec.AttrSpec{Name: "ssh_bastion_private_key_file", Type: cty.String, Required: false},
-		"ssh_bastion_certificate_file": &hcldec.AttrSpec{Name: "ssh_bastion_certificate_file", Type: cty.String, Required: false},
-		"ssh_file_transfer_method":     &hcldec.AttrSpec{Name: "ssh_file_transfer_method", Type: cty.String, Required: false},
-		"ssh_proxy_host":               &hcldec.AttrSpec{Name: "ssh_proxy_host", Type: cty.String, Required: false},
-		"ssh_proxy_port":               &hcldec.AttrSpec{Name: "ssh_proxy_port", Type: cty.Number, Required: false},
-		"ssh_proxy_username":           &hcldec.AttrSpec{Name: "ssh_proxy_username", Type: cty.String, Required: false},
-		"ssh_proxy_password":           &hcldec.AttrSpec{Name: "ssh_proxy_password", Type: cty.String, Required: false},
-		"ssh_keep_alive_interval":      &hcldec.AttrSpec{Name: "ssh_keep_alive_interval", Type: cty.String, Required: false},
-		"ssh_read_write_timeout":       &hcldec.AttrSpec{Name: "ssh_read_write_timeout", Type: cty.String, Required: false},
-		"ssh_remote_tunnels":           &hcldec.AttrSpec{Name: "ssh_remote_tunnels", Type: cty.List(cty.String), Required: false},
-		"ssh_local_tunnels":            &hcldec.AttrSpec{Name: "ssh_local_tunnels", Type: cty.List(cty.String), Required: false},
-		"ssh_public_key":               &hcldec.AttrSpec{Name: "ssh_public_key", Type: cty.List(cty.Number), Required: false},
-		"ssh_private_key":              &hcldec.AttrSpec{Name: "ssh_private_key", Type: cty.List(cty.Number), Required: false},
-		"winrm_username":               &hcldec.AttrSpec{Name: "winrm_username", Type: cty.String, Required: false},
-		"winrm_password":               &hcldec.AttrSpec{Name: "winrm_password", Type: cty.String, Required: false},
-		"winrm_host":                   &hcldec.AttrSpec{Name: "winrm_host", Type: cty.String, Required: false},
-		"winrm_no_proxy":               &hcldec.AttrSpec{Name: "winrm_no_proxy", Type: cty.Bool, Required: false},
-		"winrm_port":                   &hcldec.AttrSpec{Name: "winrm_port", Type: cty.Number, Required: false},
-		"winrm_timeout":                &hcldec.AttrSpec{Name: "winrm_timeout", Type: cty.String, Required: false},
-		"winrm_use_ssl":                &hcldec.AttrSpec{Name: "winrm_use_ssl", Type: cty.Bool, Required: false},
-		"winrm_insecure":               &hcldec.AttrSpec{Name: "winrm_insecure", Type: cty.Bool, Required: false},
-		"winrm_use_ntlm":               &hcldec.AttrSpec{Name: "winrm_use_ntlm", Type: cty.Bool, Required: false},
-		"installer_app":                &hcldec.AttrSpec{Name: "installer_app", Type: cty.String, Required: false},
-		"source_vm_name":               &hcldec.AttrSpec{Name: "source_vm_name", Type: cty.String, Required: false},
-		"vm_name":                      &hcldec.AttrSpec{Name: "vm_name", Type: cty.String, Required: false},
-		"disk_size":                    &hcldec.AttrSpec{Name: "disk_size", Type: cty.String, Required: false},
-		"ram_size":                     &hcldec.AttrSpec{Name: "ram_size", Type: cty.String, Required: false},
-		"cpu_count":                    &hcldec.AttrSpec{Name: "cpu_count", Type: cty.String, Required: false},
-		"port_forwarding_rules":        &hcldec.AttrSpec{Name: "port_forwarding_rules", Type: cty.Bool, Required: false}, /* TODO(azr): could not find type */
-		"hw_uuid":                      &hcldec.AttrSpec{Name: "hw_uuid", Type: cty.String, Required: false},
-		"boot_delay":                   &hcldec.AttrSpec{Name: "boot_delay", Type: cty.String, Required: false},
-		"enable_htt":                   &hcldec.AttrSpec{Name: "enable_htt", Type: cty.Bool, Required: false},
-		"disable_htt":                  &hcldec.AttrSpec{Name: "disable_htt", Type: cty.Bool, Required: false},
-		"update_addons":                &hcldec.AttrSpec{Name: "update_addons", Type: cty.Bool, Required: false},
-		"use_anka_cp":                  &hcldec.AttrSpec{Name: "use_anka_cp", Type: cty.Bool, Required: false},
+		"packer_build_name":               &hcldec.AttrSpec{Name: "packer_build_name", Type: cty.String, Required: false},
+		"packer_builder_type":             &hcldec.AttrSpec{Name: "packer_builder_type", Type: cty.String, Required: false},
+		"packer_core_version":             &hcldec.AttrSpec{Name: "packer_core_version", Type: cty.String, Required: false},
+		"packer_debug":                    &hcldec.AttrSpec{Name: "packer_debug", Type: cty.Bool, Required: false},
+		"packer_force":                    &hcldec.AttrSpec{Name: "packer_force", Type: cty.Bool, Required: false},
+		"packer_on_error":                 &hcldec.AttrSpec{Name: "packer_on_error", Type: cty.String, Required: false},
+		"packer_user_variables":           &hcldec.AttrSpec{Name: "packer_user_variables", Type: cty.Map(cty.String), Required: false},
+		"packer_sensitive_variables":      &hcldec.AttrSpec{Name: "packer_sensitive_variables", Type: cty.List(cty.String), Required: false},
+		"communicator":                    &hcldec.AttrSpec{Name: "communicator", Type: cty.String, Required: false},
+		"pause_before_connecting":         &hcldec.AttrSpec{Name: "pause_before_connecting", Type: cty.String, Required: false},
+		"ssh_host":                        &hcldec.AttrSpec{Name: "ssh_host", Type: cty.String, Required: false},
+		"ssh_port":                        &hcldec.AttrSpec{Name: "ssh_port", Type: cty.Number, Required: false},
+		"ssh_username":                    &hcldec.AttrSpec{Name: "ssh_username", Type: cty.String, Required: false},
+		"ssh_password":                    &hcldec.AttrSpec{Name: "ssh_password", Type: cty.String, Required: false},
+		"ssh_keypair_name":                &hcldec.AttrSpec{Name: "ssh_keypair_name", Type: cty.String, Required: false},
+		"temporary_key_pair_name":         &hcldec.AttrSpec{Name: "temporary_key_pair_name", Type: cty.String, Required: false},
+		"temporary_key_pair_type":         &hcldec.AttrSpec{Name: "temporary_key_pair_type", Type: cty.String, Required: false},
+		"temporary_key_pair_bits":         &hcldec.AttrSpec{Name: "temporary_key_pair_bits", Type: cty.Number, Required: false},
+		"ssh_ciphers":                     &hcldec.AttrSpec{Name: "ssh_ciphers", Type: cty.List(cty.String), Required: false},
+		"ssh_clear_authorized_keys":       &hcldec.AttrSpec{Name: "ssh_clear_authorized_keys", Type: cty.Bool, Required: false},
+		"ssh_key_exchange_algorithms":     &hcldec.AttrSpec{Name: "ssh_key_exchange_algorithms", Type: cty.List(cty.String), Required: false},
+		"ssh_private_key_file":            &hcldec.AttrSpec{Name: "ssh_private_key_file", Type: cty.String, Required: false},
+		"ssh_certificate_file":            &hcldec.AttrSpec{Name: "ssh_certificate_file", Type: cty.String, Required: false},
+		"ssh_pty":                         &hcldec.AttrSpec{Name: "ssh_pty", Type: cty.Bool, Required: false},
+		"ssh_timeout":                     &hcldec.AttrSpec{Name: "ssh_timeout", Type: cty.String, Required: false},
+		"ssh_wait_timeout":                &hcldec.AttrSpec{Name: "ssh_wait_timeout", Type: cty.String, Required: false},
+		"ssh_agent_auth":                  &hcldec.AttrSpec{Name: "ssh_agent_auth", Type: cty.Bool, Required: false},
+		"ssh_disable_agent_forwarding":    &hcldec.AttrSpec{Name: "ssh_disable_agent_forwarding", Type: cty.Bool, Required: false},
+		"ssh_handshake_attempts":          &hcldec.AttrSpec{Name: "ssh_handshake_attempts", Type: cty.Number, Required: false},
+		"ssh_bastion_host":                &hcldec.AttrSpec{Name: "ssh_bastion_host", Type: cty.String, Required: false},
+		"ssh_bastion_port":                &hcldec.AttrSpec{Name: "ssh_bastion_port", Type: cty.Number, Required: false},
+		"ssh_bastion_agent_auth":          &hcldec.AttrSpec{Name: "ssh_bastion_agent_auth", Type: cty.Bool, Required: false},
+		"ssh_bastion_username":            &hcldec.AttrSpec{Name: "ssh_bastion_username", Type: cty.String, Required: false},
+		"ssh_bastion_password":            &hcldec.AttrSpec{Name: "ssh_bastion_password", Type: cty.String, Required: false},
+		"ssh_bastion_interactive":         &hcldec.AttrSpec{Name: "ssh_bastion_interactive", Type: cty.Bool, Required: false},
+		"ssh_bastion_private_key_file":    &hcldec.AttrSpec{Name: "ssh_bastion_private_key_file", Type: cty.String, Required: false},
+		"ssh_bastion_certificate_file":    &hcldec.AttrSpec{Name: "ssh_bastion_certificate_file", Type: cty.String, Required: false},
+		"ssh_file_transfer_method":        &hcldec.AttrSpec{Name: "ssh_file_transfer_method", Type: cty.String, Required: false},
+		"ssh_proxy_host":                  &hcldec.AttrSpec{Name: "ssh_proxy_host", Type: cty.String, Required: false},
+		"ssh_proxy_port":                  &hcldec.AttrSpec{Name: "ssh_proxy_port", Type: cty.Number, Required: false},
+		"ssh_proxy_username":              &hcldec.AttrSpec{Name: "ssh_proxy_username", Type: cty.String, Required: false},
+		"ssh_proxy_password":              &hcldec.AttrSpec{Name: "ssh_proxy_password", Type: cty.String, Required: false},
+		"ssh_keep_alive_interval":         &hcldec.AttrSpec{Name: "ssh_keep_alive_interval", Type: cty.String, Required: false},
+		"ssh_read_write_timeout":          &hcldec.AttrSpec{Name: "ssh_read_write_timeout", Type: cty.String, Required: false},
+		"ssh_remote_tunnels":              &hcldec.AttrSpec{Name: "ssh_remote_tunnels", Type: cty.List(cty.String), Required: false},
+		"ssh_local_tunnels":               &hcldec.AttrSpec{Name: "ssh_local_tunnels", Type: cty.List(cty.String), Required: false},
+		"ssh_public_key":                  &hcldec.AttrSpec{Name: "ssh_public_key", Type: cty.List(cty.Number), Required: false},
+		"ssh_private_key":                 &hcldec.AttrSpec{Name: "ssh_private_key", Type: cty.List(cty.Number), Required: false},
+		"winrm_username":                  &hcldec.AttrSpec{Name: "winrm_username", Type: cty.String, Required: false},
+		"winrm_password":                  &hcldec.AttrSpec{Name: "winrm_password", Type: cty.String, Required: false},
+		"winrm_host":                      &hcldec.AttrSpec{Name: "winrm_host", Type: cty.String, Required: false},
+		"winrm_no_proxy":                  &hcldec.AttrSpec{Name: "winrm_no_proxy", Type: cty.Bool, Required: false},
+		"winrm_port":                      &hcldec.AttrSpec{Name: "winrm_port", Type: cty.Number, Required: false},
+		"winrm_timeout":                   &hcldec.AttrSpec{Name: "winrm_timeout", Type: cty.String, Required: false},
+		"winrm_use_ssl":                   &hcldec.AttrSpec{Name: "winrm_use_ssl", Type: cty.Bool, Required: false},
+		"winrm_insecure":                  &hcldec.AttrSpec{Name: "winrm_insecure", Type: cty.Bool, Required: false},
+		"winrm_use_ntlm":                  &hcldec.AttrSpec{Name: "winrm_use_ntlm", Type: cty.Bool, Required: false},
+		"boot_keygroup_interval":          &hcldec.AttrSpec{Name: "boot_keygroup_interval", Type: cty.String, Required: false},
+		"boot_wait":                       &hcldec.AttrSpec{Name: "boot_wait", Type: cty.String, Required: false},
+		"boot_command":                    &hcldec.AttrSpec{Name: "boot_command", Type: cty.List(cty.String), Required: false},
+		"disable_vnc":                     &hcldec.AttrSpec{Name: "disable_vnc", Type: cty.Bool, Required: false},
+		"boot_key_interval":               &hcldec.AttrSpec{Name: "boot_key_interval", Type: cty.String, Required: false},
+		"installer_app":                   &hcldec.AttrSpec{Name: "installer_app", Type: cty.String, Required: false},
+		"installer_version":               &hcldec.AttrSpec{Name: "installer_version", Type: cty.String, Required: false},
+		"installer_search_paths":          &hcldec.AttrSpec{Name: "installer_search_paths", Type: cty.List(cty.String), Required: false},
+		"base_vm_name":                    &hcldec.AttrSpec{Name: "base_vm_name", Type: cty.String, Required: false},
+		"rebuild_base_vm":                 &hcldec.AttrSpec{Name: "rebuild_base_vm", Type: cty.Bool, Required: false},
+		"base_vm_mismatch":                &hcldec.AttrSpec{Name: "base_vm_mismatch", Type: cty.String, Required: false},
+		"lock_timeout":                    &hcldec.AttrSpec{Name: "lock_timeout", Type: cty.String, Required: false},
+		"source_vm_name":                  &hcldec.AttrSpec{Name: "source_vm_name", Type: cty.String, Required: false},
+		"vm_name":                         &hcldec.AttrSpec{Name: "vm_name", Type: cty.String, Required: false},
+		"disk_size":                       &hcldec.AttrSpec{Name: "disk_size", Type: cty.String, Required: false},
+		"ram_size":                        &hcldec.AttrSpec{Name: "ram_size", Type: cty.String, Required: false},
+		"cpu_count":                       &hcldec.AttrSpec{Name: "cpu_count", Type: cty.String, Required: false},
+		"port_forwarding_rules":           &hcldec.BlockListSpec{TypeName: "port_forwarding_rules", Nested: hcldec.ObjectSpec((*FlatPortForwardingRule)(nil).HCL2Spec())},
+		"port_forwarding_rules_exclusive": &hcldec.AttrSpec{Name: "port_forwarding_rules_exclusive", Type: cty.Bool, Required: false},
+		"network_card":                    &hcldec.BlockListSpec{TypeName: "network_card", Nested: hcldec.ObjectSpec((*FlatNetworkCard)(nil).HCL2Spec())},
+		"display_resolution":              &hcldec.AttrSpec{Name: "display_resolution", Type: cty.String, Required: false},
+		"headless":                        &hcldec.AttrSpec{Name: "headless", Type: cty.Bool, Required: false},
+		"vnc_port":                        &hcldec.AttrSpec{Name: "vnc_port", Type: cty.Number, Required: false},
+		"vnc_bind_address":                &hcldec.AttrSpec{Name: "vnc_bind_address", Type: cty.String, Required: false},
+		"vnc_password":                    &hcldec.AttrSpec{Name: "vnc_password", Type: cty.String, Required: false},
+		"hw_uuid":                         &hcldec.AttrSpec{Name: "hw_uuid", Type: cty.String, Required: false},
+		"custom_variables":                &hcldec.AttrSpec{Name: "custom_variables", Type: cty.Map(cty.String), Required: false},
+		"custom_variables_exclusive":      &hcldec.AttrSpec{Name: "custom_variables_exclusive", Type: cty.Bool, Required: false},
+		"boot_delay":                      &hcldec.AttrSpec{Name: "boot_delay", Type: cty.String, Required: false},
+		"enable_htt":                      &hcldec.AttrSpec{Name: "enable_htt", Type: cty.Bool, Required: false},
+		"disable_htt":                     &hcldec.AttrSpec{Name: "disable_htt", Type: cty.Bool, Required: false},
+		"update_addons":                   &hcldec.AttrSpec{Name: "update_addons", Type: cty.Bool, Required: false},
+		"use_anka_cp":                     &hcldec.AttrSpec{Name: "use_anka_cp", Type: cty.Bool, Required: false},
+		"file_transfer_method":            &hcldec.AttrSpec{Name: "file_transfer_method", Type: cty.String, Required: false},
+		"guest_env":                       &hcldec.AttrSpec{Name: "guest_env", Type: cty.Map(cty.String), Required: false},
+		"guest_shell":                     &hcldec.AttrSpec{Name: "guest_shell", Type: cty.String, Required: false},
+		"guest_login_shell":               &hcldec.AttrSpec{Name: "guest_login_shell", Type: cty.Bool, Required: false},
+		"reboot_timeout":                  &hcldec.AttrSpec{Name: "reboot_timeout", Type: cty.String, Required: false},
+		"run_as_user":                     &hcldec.AttrSpec{Name: "run_as_user", Type: cty.String, Required: false},
+		"use_sudo":                        &hcldec.AttrSpec{Name: "use_sudo", Type: cty.Bool, Required: false},
+		"sudo_password":                   &hcldec.AttrSpec{Name: "sudo_password", Type: cty.String, Required: false},
+		"expected_macos_version":          &hcldec.AttrSpec{Name: "expected_macos_version", Type: cty.String, Required: false},
+		"destroy_with_running_clones":     &hcldec.AttrSpec{Name: "destroy_with_running_clones", Type: cty.Bool, Required: false},
+		"destroy_pushed_template":         &hcldec.AttrSpec{Name: "destroy_pushed_template", Type: cty.Bool, Required: false},
+		"destroy_registry_template":       &hcldec.AttrSpec{Name: "destroy_registry_template", Type: cty.Bool, Required: false},
+		"destroy_registry_tag":            &hcldec.AttrSpec{Name: "destroy_registry_tag", Type: cty.String, Required: false},
+		"anka_audit_log":                  &hcldec.AttrSpec{Name: "anka_audit_log", Type: cty.String, Required: false},
+		"diagnostics_dir":                 &hcldec.AttrSpec{Name: "diagnostics_dir", Type: cty.String, Required: false},
+		"on_error_collect":                &hcldec.BlockSpec{TypeName: "on_error_collect", Nested: hcldec.ObjectSpec((*FlatOnErrorCollect)(nil).HCL2Spec())},
+	}
+	return s
+}
+
+// FlatNetworkCard is an auto-generated flat version of NetworkCard.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatNetworkCard struct {
+	Index               *int    `mapstructure:"index" cty:"index" hcl:"index"`
+	Mode                *string `mapstructure:"mode" cty:"mode" hcl:"mode"`
+	MacAddress          *string `mapstructure:"mac_address" cty:"mac_address" hcl:"mac_address"`
+	RandomizeMacAddress *bool   `mapstructure:"randomize_mac_address" cty:"randomize_mac_address" hcl:"randomize_mac_address"`
+	Type                *string `mapstructure:"type" cty:"type" hcl:"type"`
+	FinalMode           *string `mapstructure:"final_mode" cty:"final_mode" hcl:"final_mode"`
+}
+
+// FlatMapstructure returns a new FlatNetworkCard.
+// FlatNetworkCard is an auto-generated flat version of NetworkCard.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*NetworkCard) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatNetworkCard)
+}
+
+// HCL2Spec returns the hcl spec of a NetworkCard.
+// This spec is used by HCL to read the fields of NetworkCard.
+// The decoded values from this spec will then be applied to a FlatNetworkCard.
+func (*FlatNetworkCard) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"index":                 &hcldec.AttrSpec{Name: "index", Type: cty.Number, Required: false},
+		"mode":                  &hcldec.AttrSpec{Name: "mode", Type: cty.String, Required: false},
+		"mac_address":           &hcldec.AttrSpec{Name: "mac_address", Type: cty.String, Required: false},
+		"randomize_mac_address": &hcldec.AttrSpec{Name: "randomize_mac_address", Type: cty.Bool, Required: false},
+		"type":                  &hcldec.AttrSpec{Name: "type", Type: cty.String, Required: false},
+		"final_mode":            &hcldec.AttrSpec{Name: "final_mode", Type: cty.String, Required: false},
+	}
+	return s
+}
+
+// FlatOnErrorCollect is an auto-generated flat version of OnErrorCollect.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatOnErrorCollect struct {
+	Files    []string `mapstructure:"files" cty:"files" hcl:"files"`
+	Commands []string `mapstructure:"commands" cty:"commands" hcl:"commands"`
+}
+
+// FlatMapstructure returns a new FlatOnErrorCollect.
+// FlatOnErrorCollect is an auto-generated flat version of OnErrorCollect.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*OnErrorCollect) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatOnErrorCollect)
+}
+
+// HCL2Spec returns the hcl spec of a OnErrorCollect.
+// This spec is used by HCL to read the fields of OnErrorCollect.
+// The decoded values from this spec will then be applied to a FlatOnErrorCollect.
+func (*FlatOnErrorCollect) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"files":    &hcldec.AttrSpec{Name: "files", Type: cty.List(cty.String), Required: false},
+		"commands": &hcldec.AttrSpec{Name: "commands", Type: cty.List(cty.String), Required: false},
+	}
+	return s
+}
+
+// FlatPortForwardingRule is an auto-generated flat version of PortForwardingRule.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatPortForwardingRule struct {
+	PortForwardingGuestPort *int    `mapstructure:"port_forwarding_guest_port" cty:"port_forwarding_guest_port" hcl:"port_forwarding_guest_port"`
+	PortForwardingHostPort  *int    `mapstructure:"port_forwarding_host_port" cty:"port_forwarding_host_port" hcl:"port_forwarding_host_port"`
+	PortForwardingRuleName  *string `mapstructure:"port_forwarding_rule_name" cty:"port_forwarding_rule_name" hcl:"port_forwarding_rule_name"`
+	PortForwardingProtocol  *string `mapstructure:"port_forwarding_protocol" cty:"port_forwarding_protocol" hcl:"port_forwarding_protocol"`
+	PortForwardingHostIP    *string `mapstructure:"port_forwarding_host_ip" cty:"port_forwarding_host_ip" hcl:"port_forwarding_host_ip"`
+	PortForwardingNICIndex  *int    `mapstructure:"port_forwarding_nic_index" cty:"port_forwarding_nic_index" hcl:"port_forwarding_nic_index"`
+	PortForwardingTemporary *bool   `mapstructure:"port_forwarding_temporary" cty:"port_forwarding_temporary" hcl:"port_forwarding_temporary"`
+}
+
+// FlatMapstructure returns a new FlatPortForwardingRule.
+// FlatPortForwardingRule is an auto-generated flat version of PortForwardingRule.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*PortForwardingRule) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatPortForwardingRule)
+}
+
+// HCL2Spec returns the hcl spec of a PortForwardingRule.
+// This spec is used by HCL to read the fields of PortForwardingRule.
+// The decoded values from this spec will then be applied to a FlatPortForwardingRule.
+func (*FlatPortForwardingRule) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"port_forwarding_guest_port": &hcldec.AttrSpec{Name: "port_forwarding_guest_port", Type: cty.Number, Required: false},
+		"port_forwarding_host_port":  &hcldec.AttrSpec{Name: "port_forwarding_host_port", Type: cty.Number, Required: false},
+		"port_forwarding_rule_name":  &hcldec.AttrSpec{Name: "port_forwarding_rule_name", Type: cty.String, Required: false},
+		"port_forwarding_protocol":   &hcldec.AttrSpec{Name: "port_forwarding_protocol", Type: cty.String, Required: false},
+		"port_forwarding_host_ip":    &hcldec.AttrSpec{Name: "port_forwarding_host_ip", Type: cty.String, Required: false},
+		"port_forwarding_nic_index":  &hcldec.AttrSpec{Name: "port_forwarding_nic_index", Type: cty.Number, Required: false},
+		"port_forwarding_temporary":  &hcldec.AttrSpec{Name: "port_forwarding_temporary", Type: cty.Bool, Required: false},
 	}
 	return s
 }
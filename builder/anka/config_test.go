@@ -0,0 +1,197 @@
+package anka
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewConfig_CustomVariables(t *testing.T) {
+	c := testConfig()
+	c["hw_uuid"] = "F9E8A1B2-0000-0000-0000-000000000000"
+	c["custom_variables"] = map[string]string{
+		"hw.serial": "C02ABCDEFGH",
+		"hw.model":  "iMacPro1,1",
+	}
+
+	config, err := NewConfig(c)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(config.CustomVariables) != 3 {
+		t.Fatalf("Expected hw_uuid to be merged into custom_variables, got %v", config.CustomVariables)
+	}
+	if config.CustomVariables["hw.UUID"] != "F9E8A1B2-0000-0000-0000-000000000000" {
+		t.Fatalf("Unexpected hw.UUID: %q", config.CustomVariables["hw.UUID"])
+	}
+}
+
+func TestNewConfig_CustomVariablesInvalid(t *testing.T) {
+	c := testConfig()
+	c["custom_variables"] = map[string]string{
+		"hw.bogus": "value",
+	}
+	if _, err := NewConfig(c); err == nil {
+		t.Fatal("Expected an error for an unknown custom variable")
+	}
+
+	c = testConfig()
+	c["hw_uuid"] = "one"
+	c["custom_variables"] = map[string]string{
+		"hw.UUID": "two",
+	}
+	if _, err := NewConfig(c); err == nil {
+		t.Fatal("Expected an error for conflicting hw_uuid and hw.UUID")
+	}
+}
+
+func TestNewConfig_SudoPassword(t *testing.T) {
+	c := testConfig()
+	c["sudo_password"] = "secret"
+	if _, err := NewConfig(c); err == nil {
+		t.Fatal("Expected an error for sudo_password without use_sudo or run_as_user")
+	}
+
+	c["run_as_user"] = "builder"
+	if _, err := NewConfig(c); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+}
+
+func TestNewConfig_GuestShell(t *testing.T) {
+	c := testConfig()
+	config, err := NewConfig(c)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if config.GuestShell != "sh" {
+		t.Fatalf("Unexpected default guest_shell %q", config.GuestShell)
+	}
+
+	for _, shell := range []string{"zsh", "/opt/homebrew/bin/fish"} {
+		c["guest_shell"] = shell
+		if _, err := NewConfig(c); err != nil {
+			t.Fatalf("Unexpected error for %s: %s", shell, err)
+		}
+	}
+
+	c["guest_shell"] = "fish"
+	if _, err := NewConfig(c); err == nil {
+		t.Fatal("Expected an error for a relative guest_shell")
+	}
+}
+
+func TestNewConfig_ExpectedMacOSVersion(t *testing.T) {
+	c := testConfig()
+	c["expected_macos_version"] = ">= 11.2"
+	if _, err := NewConfig(c); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	c["expected_macos_version"] = "Big Sur"
+	if _, err := NewConfig(c); err == nil {
+		t.Fatal("Expected an error for an invalid expected_macos_version")
+	}
+}
+
+func TestNewConfig_InstallerVersion(t *testing.T) {
+	c := testConfig()
+	delete(c, "installer_app")
+	c["installer_version"] = "~> 16.4"
+	config, err := NewConfig(c)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(config.InstallerSearchPaths) != 1 || config.InstallerSearchPaths[0] != "/Applications" {
+		t.Fatalf("Unexpected default installer_search_paths %v", config.InstallerSearchPaths)
+	}
+
+	c["installer_app"] = "/Applications/Install macOS Big Sur.app"
+	if _, err := NewConfig(c); err == nil {
+		t.Fatal("Expected an error for installer_app with installer_version")
+	}
+
+	c = testConfig()
+	c["installer_search_paths"] = []string{"/Volumes/Installers"}
+	if _, err := NewConfig(c); err == nil {
+		t.Fatal("Expected an error for installer_search_paths without installer_version")
+	}
+}
+
+func TestNewConfig_BaseVM(t *testing.T) {
+	c := testConfig()
+	c["base_vm_mismatch"] = "fail"
+	c["rebuild_base_vm"] = true
+	if _, err := NewConfig(c); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	c["base_vm_mismatch"] = "ignore"
+	if _, err := NewConfig(c); err == nil {
+		t.Fatal("Expected an error for an unknown base_vm_mismatch")
+	}
+
+	c = testConfig()
+	delete(c, "installer_app")
+	c["source_vm_name"] = "10.15.6"
+	c["rebuild_base_vm"] = true
+	if _, err := NewConfig(c); err == nil {
+		t.Fatal("Expected an error for rebuild_base_vm without an installer")
+	}
+}
+
+func TestNewConfig_SSHCommunicator(t *testing.T) {
+	c := testConfig()
+	c["communicator"] = "ssh"
+	if _, err := NewConfig(c); err == nil {
+		t.Fatal("Expected an error for the ssh communicator without ssh_username")
+	}
+
+	c["ssh_username"] = "anka"
+	c["ssh_port"] = 2222
+	config, err := NewConfig(c)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if config.Comm.SSHPort != 2222 {
+		t.Fatalf("Unexpected ssh_port %d", config.Comm.SSHPort)
+	}
+}
+
+func TestNewConfig_LockTimeout(t *testing.T) {
+	config, err := NewConfig(testConfig())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	// Long enough to wait for another build creating the base VM
+	if config.LockTimeout < 2*time.Hour {
+		t.Fatalf("Unexpected default lock_timeout %s", config.LockTimeout)
+	}
+}
+
+func TestNewConfig_PortForwardingRules(t *testing.T) {
+	c := testConfig()
+	c["port_forwarding_rules"] = []map[string]interface{}{
+		{"port_forwarding_guest_port": 80, "port_forwarding_host_port": 8080},
+		{"port_forwarding_guest_port": 53, "port_forwarding_host_port": 8080, "port_forwarding_protocol": "udp"},
+	}
+	if _, err := NewConfig(c); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	c["port_forwarding_rules"] = []map[string]interface{}{
+		{"port_forwarding_guest_port": 80, "port_forwarding_rule_name": "web"},
+		{"port_forwarding_guest_port": 443, "port_forwarding_rule_name": "web"},
+	}
+	if _, err := NewConfig(c); err == nil || !strings.Contains(err.Error(), "duplicate port forwarding rule name") {
+		t.Fatalf("Expected a duplicate rule name error, got %v", err)
+	}
+
+	c["port_forwarding_rules"] = []map[string]interface{}{
+		{"port_forwarding_guest_port": 80, "port_forwarding_host_port": 8080},
+		{"port_forwarding_guest_port": 8000, "port_forwarding_host_port": 8080, "port_forwarding_protocol": "TCP"},
+	}
+	if _, err := NewConfig(c); err == nil || !strings.Contains(err.Error(), "same host port 8080") {
+		t.Fatalf("Expected a duplicate host port error, got %v", err)
+	}
+}
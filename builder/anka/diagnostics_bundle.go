@@ -0,0 +1,162 @@
+package anka
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+// diagnosticsTimeout bounds each guest file or command collected on failure,
+// since a broken guest is the reason we are collecting in the first place.
+var diagnosticsTimeout = 2 * time.Minute
+
+func (c OnErrorCollect) empty() bool {
+	return len(c.Files) == 0 && len(c.Commands) == 0
+}
+
+var unsafeNameRegexp = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func diagnosticsEntryName(s string) string {
+	name := strings.Trim(unsafeNameRegexp.ReplaceAllString(s, "_"), "_")
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	return name
+}
+
+// writeDiagnosticsBundle writes a gzipped tarball to path with the host-side
+// description of the VM and, when comm isn't nil, the guest files and command
+// output listed in collect.
+func writeDiagnosticsBundle(path string, vmName string, cmdClient *client.Client, comm packer.Communicator, collect OnErrorCollect) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	add := func(name string, data []byte) error {
+		header := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(data)),
+			ModTime: time.Now(),
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	// The bodies are kept as anka printed them, with the fields the client
+	// doesn't model
+	addBody := func(name string, args ...string) error {
+		body, err := cmdClient.RawBody(args...)
+		if err != nil {
+			return add(name+".error", []byte(err.Error()))
+		}
+		return add(name+".json", body)
+	}
+
+	if err := addBody("host/describe", "describe", vmName); err != nil {
+		return err
+	}
+	if err := addBody("host/show", "show", vmName); err != nil {
+		return err
+	}
+
+	if comm != nil {
+		for _, guestPath := range collect.Files {
+			name := "guest/files/" + diagnosticsEntryName(guestPath)
+			content, err := withDiagnosticsTimeout(func(ctx context.Context) ([]byte, error) {
+				var buf bytes.Buffer
+				err := comm.Download(guestPath, &buf)
+				return buf.Bytes(), err
+			})
+			if err != nil {
+				name += ".error"
+				content = []byte(err.Error())
+			}
+			if err := add(name, content); err != nil {
+				return err
+			}
+		}
+
+		for i, command := range collect.Commands {
+			output := runDiagnosticsCommand(comm, command)
+			name := fmt.Sprintf("guest/commands/%02d-%s.txt", i, diagnosticsEntryName(command))
+			if err := add(name, output); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func runDiagnosticsCommand(comm packer.Communicator, command string) []byte {
+	output, err := withDiagnosticsTimeout(func(ctx context.Context) ([]byte, error) {
+		var stdout, stderr bytes.Buffer
+		remote := &packer.RemoteCmd{
+			Command: command,
+			Stdout:  &stdout,
+			Stderr:  &stderr,
+		}
+		if err := comm.Start(ctx, remote); err != nil {
+			return nil, err
+		}
+		exitStatus := remote.Wait()
+
+		var output bytes.Buffer
+		fmt.Fprintf(&output, "$ %s\n", command)
+		fmt.Fprintf(&output, "exit status: %d\n", exitStatus)
+		fmt.Fprintf(&output, "--- stdout ---\n%s\n--- stderr ---\n%s", stdout.Bytes(), stderr.Bytes())
+		return output.Bytes(), nil
+	})
+	if err != nil {
+		return []byte(fmt.Sprintf("$ %s\nerror: %s\n", command, err))
+	}
+	return output
+}
+
+// withDiagnosticsTimeout runs fn with a context that is cancelled after
+// diagnosticsTimeout. fn writes to buffers of its own and only returns its
+// result once done, so a late fn never touches what was already collected.
+func withDiagnosticsTimeout(fn func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticsTimeout)
+	defer cancel()
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		data, err := fn(ctx)
+		done <- result{data, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.data, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timed out after %s", diagnosticsTimeout)
+	}
+}
@@ -0,0 +1,206 @@
+package anka
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+// diagnosticsCommunicator serves fixed guest files and echoes commands. The
+// "hang" file and command keep writing output until they are stopped.
+type diagnosticsCommunicator struct {
+	packer.Communicator
+	files map[string]string
+
+	stop      chan struct{}
+	cancelled chan struct{}
+}
+
+func (c *diagnosticsCommunicator) Start(ctx context.Context, remote *packer.RemoteCmd) error {
+	if remote.Command == "hang" {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					close(c.cancelled)
+					remote.SetExited(1)
+					return
+				default:
+					fmt.Fprint(remote.Stdout, "output")
+				}
+			}
+		}()
+		return nil
+	}
+	fmt.Fprintf(remote.Stdout, "ran %s", remote.Command)
+	go remote.SetExited(0)
+	return nil
+}
+
+func (c *diagnosticsCommunicator) Download(src string, dst io.Writer) error {
+	if src == "/hang" {
+		for {
+			select {
+			case <-c.stop:
+				return nil
+			default:
+				io.WriteString(dst, "content")
+			}
+		}
+	}
+	content, ok := c.files[src]
+	if !ok {
+		return errors.New("no such file")
+	}
+	_, err := io.WriteString(dst, content)
+	return err
+}
+
+func readDiagnosticsBundle(t *testing.T, path string) map[string]string {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(tr)
+		entries[header.Name] = string(data)
+	}
+	return entries
+}
+
+const (
+	describeBody = `{"name": "vm", "uuid": "vm-id", "not_modeled": {"key": "value"}}`
+	showBody     = `{"name": "vm", "uuid": "vm-id", "status": "running", "not_modeled": 1}`
+)
+
+func TestWriteDiagnosticsBundle(t *testing.T) {
+	withFakeAnka(t, map[string]string{
+		"describe vm": `{"status": "OK", "body": ` + describeBody + `}`,
+		"show vm":     `{"status": "OK", "body": ` + showBody + `}`,
+	})
+
+	dir, err := ioutil.TempDir("", "anka-diagnostics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bundle.tar.gz")
+
+	comm := &diagnosticsCommunicator{files: map[string]string{
+		"/var/log/install.log": "install log contents",
+	}}
+	collect := OnErrorCollect{
+		Files:    []string{"/var/log/install.log", "/missing"},
+		Commands: []string{"sw_vers"},
+	}
+	if err := writeDiagnosticsBundle(path, "vm", &client.Client{}, comm, collect); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	entries := readDiagnosticsBundle(t, path)
+	if entries["guest/files/var_log_install.log"] != "install log contents" {
+		t.Fatalf("Missing guest file, got entries %v", entries)
+	}
+	if _, ok := entries["guest/files/missing.error"]; !ok {
+		t.Fatalf("Missing error entry for a missing guest file, got entries %v", entries)
+	}
+	if output := entries["guest/commands/00-sw_vers.txt"]; !strings.Contains(output, "ran sw_vers") || !strings.Contains(output, "exit status: 0") {
+		t.Fatalf("Unexpected command output: %q", output)
+	}
+	// Kept as anka printed them, with the fields the client doesn't model
+	if describe := entries["host/describe.json"]; describe != describeBody {
+		t.Fatalf("Unexpected host describe output %q, got entries %v", describe, entries)
+	}
+	if show := entries["host/show.json"]; show != showBody {
+		t.Fatalf("Unexpected host show output %q, got entries %v", show, entries)
+	}
+}
+
+func TestWriteDiagnosticsBundleTimeout(t *testing.T) {
+	withFakeAnka(t, nil)
+	timeout := diagnosticsTimeout
+	diagnosticsTimeout = 50 * time.Millisecond
+	defer func() { diagnosticsTimeout = timeout }()
+
+	dir, err := ioutil.TempDir("", "anka-diagnostics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bundle.tar.gz")
+
+	comm := &diagnosticsCommunicator{stop: make(chan struct{}), cancelled: make(chan struct{})}
+	defer close(comm.stop)
+	collect := OnErrorCollect{Files: []string{"/hang"}, Commands: []string{"hang"}}
+	if err := writeDiagnosticsBundle(path, "vm", &client.Client{}, comm, collect); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	entries := readDiagnosticsBundle(t, path)
+	if output := entries["guest/files/hang.error"]; !strings.Contains(output, "timed out") {
+		t.Fatalf("Expected a timeout for the file, got entries %v", entries)
+	}
+	if output := entries["guest/commands/00-hang.txt"]; output != "$ hang\nerror: timed out after 50ms\n" {
+		t.Fatalf("Unexpected command output: %q", output)
+	}
+	select {
+	case <-comm.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The command wasn't cancelled")
+	}
+}
+
+func TestStepDiagnosticsCollectsOnce(t *testing.T) {
+	withFakeAnka(t, nil)
+
+	dir, err := ioutil.TempDir("", "anka-diagnostics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	state := new(multistep.BasicStateBag)
+	state.Put("config", &Config{DiagnosticsDir: dir})
+	state.Put("ui", packer.TestUi(t))
+	state.Put("client", &client.Client{})
+	state.Put("vm_name", "vm")
+	state.Put("error", errors.New("provisioning failed"))
+
+	// The step after the communicator collects, the one after StepCreateVM
+	// then leaves it alone
+	for i := 0; i < 2; i++ {
+		(&StepDiagnostics{}).Cleanup(state)
+	}
+
+	err = state.Get("error").(error)
+	if strings.Count(err.Error(), "diagnostics bundle saved") != 1 {
+		t.Fatalf("Expected diagnostics to be collected once, got error %q", err)
+	}
+}
@@ -0,0 +1,75 @@
+package anka
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+var resolutionRegexp = regexp.MustCompile(`^([0-9]+)x([0-9]+)$`)
+
+// parseResolution parses a "<width>x<height>" display resolution.
+func parseResolution(resolution string) (int, int, error) {
+	match := resolutionRegexp.FindStringSubmatch(resolution)
+	if match == nil {
+		return 0, 0, fmt.Errorf("display_resolution %q must be in <width>x<height> format", resolution)
+	}
+	width, _ := strconv.Atoi(match[1])
+	height, _ := strconv.Atoi(match[2])
+	return width, height, nil
+}
+
+// planDisplay returns the `anka modify <vm> set` arguments needed to apply the
+// display and VNC configuration, skipping values the VM already has.
+func planDisplay(display client.Display, config *Config) [][]string {
+	var modifications [][]string
+
+	displayFlags := []string{}
+	if config.DisplayResolution != "" {
+		width, height, _ := parseResolution(config.DisplayResolution)
+		if display.FrameBuffer.Width != width || display.FrameBuffer.Height != height {
+			displayFlags = append(displayFlags, "--resolution", config.DisplayResolution)
+		}
+	}
+	if config.Headless.True() && display.Headless == 0 {
+		displayFlags = append(displayFlags, "--headless")
+	}
+	if config.Headless.False() && display.Headless != 0 {
+		displayFlags = append(displayFlags, "--no-headless")
+	}
+	if len(displayFlags) > 0 {
+		modifications = append(modifications, append([]string{"display"}, displayFlags...))
+	}
+
+	vncFlags := []string{}
+	if config.VNCPort != 0 && display.FrameBuffer.VncPort != config.VNCPort {
+		vncFlags = append(vncFlags, "--port", strconv.Itoa(config.VNCPort))
+	}
+	if config.VNCBindAddress != "" && display.FrameBuffer.VncIP != config.VNCBindAddress {
+		vncFlags = append(vncFlags, "--ip", config.VNCBindAddress)
+	}
+	if config.VNCPassword != "" && display.FrameBuffer.Password != config.VNCPassword {
+		vncFlags = append(vncFlags, "--password", config.VNCPassword)
+	}
+	if len(vncFlags) > 0 {
+		modifications = append(modifications, append([]string{"vnc"}, vncFlags...))
+	}
+
+	return modifications
+}
+
+// vncAddress returns the host:port the VM's VNC server can be reached at, or
+// an empty string when VNC isn't enabled.
+func vncAddress(frameBuffer client.FrameBuffer) string {
+	if frameBuffer.VncPort == 0 {
+		return ""
+	}
+	ip := frameBuffer.VncIP
+	if ip == "" || ip == "0.0.0.0" {
+		ip = "127.0.0.1"
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(frameBuffer.VncPort))
+}
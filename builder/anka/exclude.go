@@ -0,0 +1,103 @@
+package anka
+
+import (
+	"path"
+	"strings"
+)
+
+// excludeMatcher decides which files of a directory transfer are left out.
+// Patterns are globs matched against paths relative to the transferred
+// directory: a pattern without a slash matches a name at any depth, one with
+// a slash matches from the top, and a trailing slash only matches
+// directories. Everything below an excluded directory is excluded too.
+type excludeMatcher struct {
+	patterns []excludePattern
+
+	// root is the name paths start with when the transferred directory
+	// itself is in them
+	root string
+}
+
+type excludePattern struct {
+	glob     string
+	anchored bool
+	dirOnly  bool
+}
+
+func newExcludeMatcher(patterns []string) *excludeMatcher {
+	m := &excludeMatcher{}
+	for _, pattern := range patterns {
+		p := excludePattern{glob: pattern}
+		if strings.HasSuffix(p.glob, "/") {
+			p.dirOnly = true
+			p.glob = strings.TrimRight(p.glob, "/")
+		}
+		p.glob = strings.TrimPrefix(p.glob, "./")
+		if strings.Contains(p.glob, "/") {
+			p.anchored = true
+			p.glob = strings.TrimPrefix(p.glob, "/")
+		}
+		if p.glob != "" {
+			m.patterns = append(m.patterns, p)
+		}
+	}
+	return m
+}
+
+// newDownloadExcludeMatcher matches patterns relative to the directory src
+// names, as UploadDir does, although the downloaded paths start with its
+// name unless src has a trailing slash or a glob.
+func newDownloadExcludeMatcher(src string, patterns []string) *excludeMatcher {
+	m := newExcludeMatcher(patterns)
+	if _, base := path.Split(path.Clean(src)); !strings.HasSuffix(src, "/") && !strings.ContainsAny(base, "*?[") {
+		m.root = base
+	}
+	return m
+}
+
+func (m *excludeMatcher) empty() bool {
+	return m == nil || len(m.patterns) == 0
+}
+
+// excluded reports whether the file at the slash separated relative path
+// rel is excluded, either itself or through one of its parents.
+func (m *excludeMatcher) excluded(rel string, isDir bool) bool {
+	if m.empty() {
+		return false
+	}
+	rel = strings.Trim(path.Clean(strings.TrimPrefix(rel, "./")), "/")
+	if m.root != "" {
+		if rel == m.root {
+			return false
+		}
+		rel = strings.TrimPrefix(rel, m.root+"/")
+	}
+	if rel == "." || rel == "" {
+		return false
+	}
+
+	elements := strings.Split(rel, "/")
+	for i := range elements {
+		last := i == len(elements)-1
+		if m.matches(strings.Join(elements[:i+1], "/"), elements[i], isDir || !last) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *excludeMatcher) matches(rel string, name string, isDir bool) bool {
+	for _, p := range m.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		subject := name
+		if p.anchored {
+			subject = rel
+		}
+		if matched, _ := path.Match(p.glob, subject); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package anka
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+func TestExcludeMatcher(t *testing.T) {
+	m := newExcludeMatcher([]string{".git/", "node_modules", "*.log", "build/cache", "/top.txt"})
+
+	cases := []struct {
+		rel      string
+		isDir    bool
+		excluded bool
+	}{
+		{".git", true, true},
+		{".git/config", false, true},
+		{"sub/.git/HEAD", false, true},
+		{".git", false, false},
+		{"node_modules", true, true},
+		{"app/node_modules/left-pad/index.js", false, true},
+		{"debug.log", false, true},
+		{"logs/debug.log", false, true},
+		{"build/cache/object", false, true},
+		{"app/build/cache", true, false},
+		{"top.txt", false, true},
+		{"sub/top.txt", false, false},
+		{"./src/main.go", false, false},
+		{".", true, false},
+	}
+	for _, c := range cases {
+		if excluded := m.excluded(c.rel, c.isDir); excluded != c.excluded {
+			t.Errorf("excluded(%q, %t) = %t, expected %t", c.rel, c.isDir, excluded, c.excluded)
+		}
+	}
+
+	// Downloaded paths start with the name of the directory
+	m = newDownloadExcludeMatcher("/guest/app", []string{"build/cache", "/top.txt"})
+	for rel, excluded := range map[string]bool{"app": false, "app/top.txt": true, "app/lib/top.txt": false, "app/build/cache": true} {
+		if m.excluded(rel, false) != excluded {
+			t.Errorf("excluded(%q) should be %t for a download", rel, excluded)
+		}
+	}
+
+	if newExcludeMatcher(nil).excluded("anything", false) {
+		t.Error("Nothing should be excluded without patterns")
+	}
+}
+
+// writeExcludeFixture creates an app directory in root with files some
+// exclude patterns match.
+func writeExcludeFixture(t *testing.T, root string) {
+	for _, name := range []string{"app/main.go", "app/.git/HEAD", "app/node_modules/dep/index.js", "app/debug.log", "app/lib/util.go",
+		"app/build/cache/obj", "app/lib/build/cache/obj", "app/top.txt", "app/lib/top.txt"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+var (
+	transferExcludes = []string{".git/", "node_modules", "*.log", "build/cache", "/top.txt"}
+	// Relative to the transferred directory
+	transferKept     = []string{"main.go", "lib/util.go", "lib/build/cache/obj", "lib/top.txt"}
+	transferExcluded = []string{".git", "node_modules", "debug.log", "build/cache", "top.txt"}
+)
+
+// checkExcludeTransfer checks the transferred copy of the app directory in
+// dir.
+func checkExcludeTransfer(t *testing.T, dir string) {
+	for _, name := range transferKept {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("Missing transfer: %s", err)
+		}
+	}
+	for _, name := range transferExcluded {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("Excluded %s was transferred: %v", name, err)
+		}
+	}
+}
+
+func TestCommunicatorUploadDirExclude(t *testing.T) {
+	withFakeAnka(t, nil)
+
+	for _, method := range []string{fileTransferSharedVolume, fileTransferTar, fileTransferAnkaCP} {
+		t.Run(method, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "anka-exclude")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+			writeExcludeFixture(t, root)
+			if err := os.Mkdir(filepath.Join(root, "volume"), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			comm := &Communicator{
+				Config:  &Config{FileTransferMethod: method},
+				Client:  &client.Client{},
+				HostDir: filepath.Join(root, "volume"),
+				VMName:  "fake",
+			}
+			guest := filepath.Join(root, "guest")
+			if err := comm.UploadDir(guest, filepath.Join(root, "app"), transferExcludes); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			checkExcludeTransfer(t, filepath.Join(guest, "app"))
+		})
+	}
+}
+
+func TestCommunicatorDownloadDirExclude(t *testing.T) {
+	withFakeAnka(t, nil)
+
+	for _, method := range []string{fileTransferSharedVolume, fileTransferTar, fileTransferAnkaCP} {
+		for _, slash := range []string{"", "/"} {
+			t.Run(method+slash, func(t *testing.T) {
+				root, err := ioutil.TempDir("", "anka-exclude")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(root)
+				writeExcludeFixture(t, root)
+				if err := os.Mkdir(filepath.Join(root, "volume"), 0755); err != nil {
+					t.Fatal(err)
+				}
+
+				comm := &Communicator{
+					Config:  &Config{FileTransferMethod: method},
+					Client:  &client.Client{},
+					HostDir: filepath.Join(root, "volume"),
+					VMName:  "fake",
+				}
+				host := filepath.Join(root, "host")
+				if err := comm.DownloadDir(filepath.Join(root, "app")+slash, host+"/", transferExcludes); err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				// Without a trailing slash, the directory itself is downloaded
+				if slash == "" {
+					host = filepath.Join(host, "app")
+				}
+				checkExcludeTransfer(t, host)
+			})
+		}
+	}
+}
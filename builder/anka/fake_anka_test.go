@@ -0,0 +1,132 @@
+package anka
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeAnkaScript stands in for anka. Machine readable commands are recorded
+// in the calls file and answered from the response file named after their
+// arguments, or with an empty OK. anka cp copies on the host, ignoring the VM
+// name of guest paths. anka run runs the command on the host, in the mounted
+// volume or the current directory. When the down and up files exist, runs
+// from the down count until the up count fail like during a guest reboot,
+// and FAKE_BOOT tells the boot apart.
+const fakeAnkaScript = `#!/bin/sh
+dir="$(dirname "$0")"
+if [ "$1" = "--machine-readable" ]; then
+	shift
+	echo "$*" >> "$dir/calls"
+	if [ "$1" = "cp" ]; then
+		src="${3#*:}"
+		dst="${4#*:}"
+		case "$src" in
+		*/) src="$src." ;;
+		esac
+		mkdir -p "$dst" && cp -pPR "$src" "$dst" || exit 1
+	fi
+	response="$dir/$(echo "$*" | tr ' ' '_')"
+	if [ -f "$response" ]; then
+		printf '%s' "$(cat "$response")"
+	else
+		printf '%s' '{"status": "OK", "body": {}}'
+	fi
+	exit 0
+fi
+
+[ "$1" = "run" ] || exit 1
+shift
+count=$(($(cat "$dir/runs" 2>/dev/null || echo 0) + 1))
+echo $count > "$dir/runs"
+export FAKE_BOOT=1
+if [ -f "$dir/down" ] && [ $count -ge $(cat "$dir/down") ]; then
+	[ $count -ge $(cat "$dir/up") ] || exit 125
+	FAKE_BOOT=2
+fi
+if [ "$1" = "-v" ]; then
+	cd "$2" || exit 1
+	shift 2
+else
+	shift
+fi
+shift
+exec "$@"
+`
+
+// fakeAnka is a fake anka first on PATH for the duration of a test.
+type fakeAnka struct {
+	t   *testing.T
+	dir string
+}
+
+// withFakeAnka puts fakeAnkaScript first on PATH, answering machine readable
+// commands with responses, keyed by their arguments.
+func withFakeAnka(t *testing.T, responses map[string]string) *fakeAnka {
+	if runtime.GOOS == "windows" {
+		t.Skip("The fake anka is a shell script")
+	}
+
+	dir, err := ioutil.TempDir("", "fake-anka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeAnka{t: t, dir: dir}
+	fake.command("anka", fakeAnkaScript)
+	for args, response := range responses {
+		fake.write(strings.ReplaceAll(args, " ", "_"), response)
+	}
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	t.Cleanup(func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	})
+	return fake
+}
+
+func (f *fakeAnka) write(name, content string) {
+	if err := ioutil.WriteFile(filepath.Join(f.dir, name), []byte(content), 0644); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// command adds a command that anka run finds on PATH.
+func (f *fakeAnka) command(name, script string) {
+	if err := ioutil.WriteFile(filepath.Join(f.dir, name), []byte(script), 0755); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// reboot makes the runs from down until up fail like during a guest reboot.
+func (f *fakeAnka) reboot(down, up int) {
+	f.write("down", strconv.Itoa(down))
+	f.write("up", strconv.Itoa(up))
+}
+
+// calls returns the machine readable commands that were run.
+func (f *fakeAnka) calls() []string {
+	calls, err := ioutil.ReadFile(filepath.Join(f.dir, "calls"))
+	if err != nil && !os.IsNotExist(err) {
+		f.t.Fatal(err)
+	}
+	if len(calls) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSpace(string(calls)), "\n")
+}
+
+// runs returns how many times anka run was called.
+func (f *fakeAnka) runs() int {
+	runs, err := ioutil.ReadFile(filepath.Join(f.dir, "runs"))
+	if err != nil && !os.IsNotExist(err) {
+		f.t.Fatal(err)
+	}
+	count, _ := strconv.Atoi(strings.TrimSpace(string(runs)))
+	return count
+}
@@ -0,0 +1,165 @@
+package anka
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/groob/plist"
+	"github.com/hashicorp/go-version"
+)
+
+// installerAppVersion is what an installer app says about the macOS it
+// installs.
+type installerAppVersion struct {
+	// OSVersion is the macOS version, from SharedSupport/InstallInfo.plist
+	// when the installer has one. Otherwise it is the installer's
+	// DTPlatformVersion, which is often just "GM".
+	OSVersion string
+	// ShortVersion is the version of the installer app itself.
+	ShortVersion string
+	// Build is the macOS build the installer was made with.
+	Build string
+}
+
+// baseName is the suffix of the name of base VMs created from the
+// installer.
+func (v installerAppVersion) baseName() string {
+	parts := []string{v.OSVersion, v.ShortVersion}
+	if v.Build != "" {
+		parts = append(parts, v.Build)
+	}
+	return strings.Join(parts, "-")
+}
+
+func obtainMacOSVersionFromInstallerApp(path string) (installerAppVersion, error) {
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return installerAppVersion{}, fmt.Errorf("installer app does not exist at %q: %w", path, err)
+	}
+	if err != nil {
+		return installerAppVersion{}, fmt.Errorf("failed to stat installer at %q: %w", path, err)
+	}
+
+	var infoPlist struct {
+		PlatformVersion string `plist:"DTPlatformVersion"`
+		ShortVersion    string `plist:"CFBundleShortVersionString"`
+		SDKBuild        string `plist:"DTSDKBuild"`
+	}
+	plistPath := filepath.Join(path, "Contents", "Info.plist")
+	if err := readPlist(plistPath, &infoPlist); err != nil {
+		return installerAppVersion{}, fmt.Errorf("failed to read installer app info plist: %w", err)
+	}
+	if infoPlist.ShortVersion == "" {
+		return installerAppVersion{}, fmt.Errorf("installer app info plist %q has no CFBundleShortVersionString", plistPath)
+	}
+
+	version := installerAppVersion{
+		OSVersion:    infoPlist.PlatformVersion,
+		ShortVersion: infoPlist.ShortVersion,
+		Build:        infoPlist.SDKBuild,
+	}
+
+	// Only installers up to Catalina have an InstallInfo.plist
+	var installInfo struct {
+		SystemImageInfo struct {
+			Version string `plist:"version"`
+		} `plist:"System Image Info"`
+	}
+	installInfoPath := filepath.Join(path, "Contents", "SharedSupport", "InstallInfo.plist")
+	if _, err := os.Stat(installInfoPath); err == nil {
+		if err := readPlist(installInfoPath, &installInfo); err != nil {
+			return installerAppVersion{}, fmt.Errorf("failed to read installer info plist: %w", err)
+		}
+		if installInfo.SystemImageInfo.Version != "" {
+			version.OSVersion = installInfo.SystemImageInfo.Version
+		}
+	}
+
+	if version.OSVersion == "" {
+		return installerAppVersion{}, fmt.Errorf("unable to find the macOS version of installer app %q", path)
+	}
+	return version, nil
+}
+
+// findInstallerApp looks for macOS installers directly in searchPaths and
+// returns the one with the highest version matching constraint. The version
+// is the installer app's CFBundleShortVersionString, since installers since
+// Big Sur don't tell which macOS version they install.
+func findInstallerApp(searchPaths []string, constraint string) (string, installerAppVersion, error) {
+	constraints, err := version.NewConstraint(constraint)
+	if err != nil {
+		return "", installerAppVersion{}, err
+	}
+
+	var (
+		bestPath    string
+		bestVersion installerAppVersion
+		best        *version.Version
+	)
+	for _, searchPath := range searchPaths {
+		apps, err := filepath.Glob(filepath.Join(searchPath, "*.app"))
+		if err != nil {
+			return "", installerAppVersion{}, err
+		}
+		for _, app := range apps {
+			if !isInstallerApp(app) {
+				continue
+			}
+			appVersion, err := obtainMacOSVersionFromInstallerApp(app)
+			if err != nil {
+				log.Printf("Skipping installer app %q: %v", app, err)
+				continue
+			}
+			v, err := version.NewVersion(appVersion.ShortVersion)
+			if err != nil {
+				log.Printf("Skipping installer app %q with version %q: %v", app, appVersion.ShortVersion, err)
+				continue
+			}
+			if !constraints.Check(v) {
+				log.Printf("Skipping installer app %q, version %s doesn't match %q", app, v, constraint)
+				continue
+			}
+			if best == nil || v.GreaterThan(best) {
+				bestPath, bestVersion, best = app, appVersion, v
+			}
+		}
+	}
+
+	if best == nil {
+		return "", installerAppVersion{}, fmt.Errorf("no installer app matching installer_version %q found in %s", constraint, strings.Join(searchPaths, ", "))
+	}
+	return bestPath, bestVersion, nil
+}
+
+// isInstallerApp tells whether the app at path is a macOS installer.
+func isInstallerApp(path string) bool {
+	var infoPlist struct {
+		Identifier string `plist:"CFBundleIdentifier"`
+	}
+	if err := readPlist(filepath.Join(path, "Contents", "Info.plist"), &infoPlist); err != nil {
+		return false
+	}
+	return strings.HasPrefix(infoPlist.Identifier, "com.apple.InstallAssistant")
+}
+
+// readPlist decodes the XML or binary plist at path into v.
+func readPlist(path string, v interface{}) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	decoder := plist.NewXMLDecoder(bytes.NewReader(content))
+	if bytes.HasPrefix(content, []byte("bplist0")) {
+		decoder = plist.NewBinaryDecoder(bytes.NewReader(content))
+	}
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("failed to decode plist %q: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package anka
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestObtainMacOSVersionFromInstallerApp(t *testing.T) {
+	cases := []struct {
+		app      string
+		version  installerAppVersion
+		baseName string
+	}{
+		{
+			// Binary Info.plist, no InstallInfo.plist
+			app:      "Install macOS Big Sur.app",
+			version:  installerAppVersion{OSVersion: "GM", ShortVersion: "16.4.06", Build: "20D64"},
+			baseName: "GM-16.4.06-20D64",
+		},
+		{
+			// XML Info.plist with an InstallInfo.plist
+			app:      "Install macOS Catalina.app",
+			version:  installerAppVersion{OSVersion: "10.15.7", ShortVersion: "15.7.03", Build: "19H15"},
+			baseName: "10.15.7-15.7.03-19H15",
+		},
+	}
+
+	for _, c := range cases {
+		version, err := obtainMacOSVersionFromInstallerApp(filepath.Join("testdata", c.app))
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", c.app, err)
+		}
+		if version != c.version {
+			t.Fatalf("%s: unexpected version %+v", c.app, version)
+		}
+		if version.baseName() != c.baseName {
+			t.Fatalf("%s: unexpected base name %q", c.app, version.baseName())
+		}
+	}
+}
+
+func TestObtainMacOSVersionFromInstallerAppErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	empty := filepath.Join(dir, "Install macOS Empty.app")
+	if err := os.MkdirAll(filepath.Join(empty, "Contents"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tiny := filepath.Join(dir, "Install macOS Tiny.app")
+	if err := os.MkdirAll(filepath.Join(tiny, "Contents"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tiny, "Contents", "Info.plist"), []byte("bp"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, app := range []string{
+		filepath.Join(dir, "Install macOS Missing.app"),
+		empty,
+		tiny,
+		filepath.Join("testdata", "Install macOS Broken.app"),
+	} {
+		if _, err := obtainMacOSVersionFromInstallerApp(app); err == nil {
+			t.Fatalf("Expected an error for %s", app)
+		}
+	}
+}
+
+func TestFindInstallerApp(t *testing.T) {
+	cases := []struct {
+		constraint string
+		app        string
+	}{
+		{">= 15", "Install macOS Big Sur.app"},
+		{"~> 15.7", "Install macOS Catalina.app"},
+		{"< 16, >= 15.7.3", "Install macOS Catalina.app"},
+	}
+
+	for _, c := range cases {
+		app, version, err := findInstallerApp([]string{"testdata", "missing"}, c.constraint)
+		if err != nil {
+			t.Fatalf("%q: unexpected error %v", c.constraint, err)
+		}
+		if app != filepath.Join("testdata", c.app) {
+			t.Fatalf("%q: unexpected installer app %q", c.constraint, app)
+		}
+		if version.ShortVersion == "" {
+			t.Fatalf("%q: missing version", c.constraint)
+		}
+	}
+
+	if _, _, err := findInstallerApp([]string{"testdata"}, ">= 17"); err == nil {
+		t.Fatal("Expected an error without a matching installer app")
+	}
+}
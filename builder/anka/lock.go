@@ -0,0 +1,95 @@
+package anka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/filelock"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/pathing"
+)
+
+// lockRetryInterval is the time between attempts while waiting for a lock.
+var lockRetryInterval = time.Second
+
+// lockWaitMessageInterval is how often the UI is told a build is still
+// waiting for a lock.
+var lockWaitMessageInterval = time.Minute
+
+// vmLock is a host-wide lock on a VM name, so that concurrent builds don't
+// create, stop, suspend or clone the same VM at the same time.
+type vmLock struct {
+	vmName string
+	flock  *filelock.Flock
+}
+
+// lockVM waits for at most timeout to get the lock on vmName. The lock files
+// are under the Packer config directory.
+func lockVM(ctx context.Context, vmName string, timeout time.Duration, ui packer.Ui) (*vmLock, error) {
+	configDir, err := pathing.ConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	lockDir := filepath.Join(configDir, "anka-locks")
+	if err := os.MkdirAll(lockDir, 0755); err != nil {
+		return nil, err
+	}
+
+	lock := &vmLock{
+		vmName: vmName,
+		flock:  filelock.New(filepath.Join(lockDir, url.PathEscape(vmName)+".lock")),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	waitStart := time.Now()
+	lastMessage := time.Time{}
+	for {
+		locked, err := lock.flock.TryLock()
+		if err != nil {
+			return nil, err
+		}
+		if locked {
+			if !lastMessage.IsZero() {
+				ui.Say(fmt.Sprintf("Got the lock on VM %s after %s", vmName, time.Since(waitStart).Round(time.Second)))
+			}
+			log.Printf("Locked VM %s with %s", vmName, lock.flock.Path())
+			return lock, nil
+		}
+
+		if lastMessage.IsZero() {
+			ui.Say(fmt.Sprintf("Waiting up to %s for another build to release VM %s", timeout, vmName))
+			lastMessage = time.Now()
+		} else if time.Since(lastMessage) >= lockWaitMessageInterval {
+			ui.Say(fmt.Sprintf("Still waiting for another build to release VM %s (%s so far)", vmName, time.Since(waitStart).Round(time.Second)))
+			lastMessage = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, fmt.Errorf("VM %s wasn't released by another build within lock_timeout (%s)", vmName, timeout)
+			}
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// Unlock releases the lock. It can be called more than once.
+func (l *vmLock) Unlock() {
+	if !l.flock.Locked() {
+		return
+	}
+	if err := l.flock.Unlock(); err != nil {
+		log.Printf("Failed to unlock VM %s: %v", l.vmName, err)
+		return
+	}
+	log.Printf("Unlocked VM %s", l.vmName)
+}
@@ -0,0 +1,59 @@
+package anka
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+func TestLockVM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anka-locks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configDir := os.Getenv("PACKER_CONFIG_DIR")
+	os.Setenv("PACKER_CONFIG_DIR", dir)
+	defer os.Setenv("PACKER_CONFIG_DIR", configDir)
+
+	retryInterval := lockRetryInterval
+	lockRetryInterval = 10 * time.Millisecond
+	defer func() { lockRetryInterval = retryInterval }()
+
+	ui := packer.TestUi(t)
+	ctx := context.Background()
+
+	lock, err := lockVM(ctx, "anka-packer-base-11.2", time.Second, ui)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// Other VMs aren't affected
+	other, err := lockVM(ctx, "anka-packer-base-10.15", 50*time.Millisecond, ui)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	other.Unlock()
+
+	_, err = lockVM(ctx, "anka-packer-base-11.2", 50*time.Millisecond, ui)
+	if err == nil || !strings.Contains(err.Error(), "lock_timeout") {
+		t.Fatalf("Expected a lock_timeout error, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		lock.Unlock()
+	}()
+	second, err := lockVM(ctx, "anka-packer-base-11.2", time.Second, ui)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	second.Unlock()
+	second.Unlock()
+}
@@ -0,0 +1,105 @@
+package anka
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+// networkCardChange is a modification of a single network card.
+type networkCardChange struct {
+	Index int
+	Add   bool
+	Flags []string
+}
+
+// planNetworkCards returns the changes needed to configure the wanted network
+// cards, skipping properties that already have the requested value.
+func planNetworkCards(existing []client.NetworkCard, wanted []NetworkCard) []networkCardChange {
+	var changes []networkCardChange
+
+	for _, nic := range wanted {
+		var current *client.NetworkCard
+		for i := range existing {
+			if existing[i].Index == nic.Index {
+				current = &existing[i]
+				break
+			}
+		}
+
+		var flags []string
+		if nic.Mode != "" && (current == nil || !sameNetworkMode(current.Mode, nic.Mode)) {
+			flags = append(flags, "--mode", ankaNetworkMode(nic.Mode))
+		}
+		if nic.RandomizeMacAddress {
+			flags = append(flags, "--mac", randomMacAddress())
+		} else if nic.MacAddress != "" && (current == nil || !strings.EqualFold(current.MacAddress, nic.MacAddress)) {
+			flags = append(flags, "--mac", nic.MacAddress)
+		}
+		if nic.Type != "" && (current == nil || current.Type != nic.Type) {
+			flags = append(flags, "--controller", nic.Type)
+		}
+
+		if current != nil && len(flags) == 0 {
+			continue
+		}
+		changes = append(changes, networkCardChange{Index: nic.Index, Add: current == nil, Flags: flags})
+	}
+
+	return changes
+}
+
+// planFinalNetworkModes returns the changes switching network cards to their
+// final_mode.
+func planFinalNetworkModes(existing []client.NetworkCard, wanted []NetworkCard) []networkCardChange {
+	var final []NetworkCard
+	for _, nic := range wanted {
+		if nic.FinalMode != "" {
+			final = append(final, NetworkCard{Index: nic.Index, Mode: nic.FinalMode})
+		}
+	}
+	return planNetworkCards(existing, final)
+}
+
+func applyNetworkCardChanges(ankaClient *client.Client, vmName string, changes []networkCardChange, ui packer.Ui) error {
+	for _, change := range changes {
+		command := "set"
+		if change.Add {
+			command = "add"
+		}
+		ui.Say(fmt.Sprintf("Modifying VM %s network card %d (%s)", vmName, change.Index, strings.Join(change.Flags, " ")))
+		flags := append([]string{"--index", strconv.Itoa(change.Index)}, change.Flags...)
+		if err := ankaClient.Modify(vmName, command, "network-card", flags...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ankaNetworkMode returns the name anka gives to mode: the configuration
+// accepts "bridged", but anka calls bridged cards "bridge".
+func ankaNetworkMode(mode string) string {
+	mode = strings.ToLower(mode)
+	if mode == "bridged" {
+		return "bridge"
+	}
+	return mode
+}
+
+func sameNetworkMode(current, wanted string) bool {
+	return ankaNetworkMode(current) == ankaNetworkMode(wanted)
+}
+
+// randomMacAddress generates a locally administered unicast MAC address.
+func randomMacAddress() string {
+	mac := make(net.HardwareAddr, 6)
+	for i := range mac {
+		mac[i] = byte(random.Intn(256))
+	}
+	mac[0] = (mac[0] | 0x02) &^ 0x01
+	return mac.String()
+}
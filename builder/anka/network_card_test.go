@@ -0,0 +1,76 @@
+package anka
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+func TestPlanNetworkCards(t *testing.T) {
+	existing := []client.NetworkCard{
+		{Index: 0, Mode: "shared", MacAddress: "0A:00:00:00:00:01", Type: "virtio-net"},
+	}
+	wanted := []NetworkCard{
+		{Index: 0, Mode: "shared", MacAddress: "0a:00:00:00:00:01", Type: "virtio-net", FinalMode: "disconnected"},
+		{Index: 1, Mode: "bridged", RandomizeMacAddress: true},
+	}
+
+	changes := planNetworkCards(existing, wanted)
+	if len(changes) != 1 || !changes[0].Add || changes[0].Index != 1 {
+		t.Fatalf("Unexpected changes: %+v", changes)
+	}
+	if changes[0].Flags[0] != "--mode" || changes[0].Flags[1] != "bridge" || changes[0].Flags[2] != "--mac" {
+		t.Fatalf("Unexpected flags: %v", changes[0].Flags)
+	}
+	if _, err := net.ParseMAC(changes[0].Flags[3]); err != nil {
+		t.Fatalf("Invalid random mac address: %s", err)
+	}
+
+	final := planFinalNetworkModes(existing, wanted)
+	expected := []networkCardChange{{Index: 0, Flags: []string{"--mode", "disconnected"}}}
+	if !reflect.DeepEqual(final, expected) {
+		t.Fatalf("Unexpected final changes: %+v", final)
+	}
+}
+
+func TestApplyNetworkCardChanges(t *testing.T) {
+	fake := withFakeAnka(t, nil)
+
+	existing := []client.NetworkCard{{Index: 0, Mode: "bridge"}}
+	wanted := []NetworkCard{{Index: 0, Mode: "bridged"}, {Index: 1, Mode: "bridged"}}
+	changes := planNetworkCards(existing, wanted)
+	if err := applyNetworkCardChanges(&client.Client{}, "vm", changes, packer.TestUi(t)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// anka calls the mode bridge
+	expected := []string{"modify vm add network-card --index 1 --mode bridge"}
+	if !reflect.DeepEqual(fake.calls(), expected) {
+		t.Fatalf("Unexpected commands %q", fake.calls())
+	}
+}
+
+func TestNewConfig_NetworkCards(t *testing.T) {
+	c := testConfig()
+	c["network_card"] = []map[string]interface{}{
+		{"index": 0, "mode": "Shared", "final_mode": "disconnected"},
+	}
+	config, err := NewConfig(c)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if config.NetworkCards[0].Mode != "shared" {
+		t.Fatalf("Expected mode to be normalized, got %q", config.NetworkCards[0].Mode)
+	}
+
+	c["network_card"] = []map[string]interface{}{
+		{"index": 0, "mode": "nat"},
+		{"index": 0, "mac_address": "not-a-mac"},
+	}
+	if _, err := NewConfig(c); err == nil {
+		t.Fatal("Expected an error for an invalid network_card")
+	}
+}
@@ -0,0 +1,149 @@
+package anka
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+// portForwardingPlan lists the modifications needed to bring the
+// port-forwarding rules of a VM in line with the configuration. Updated rules
+// appear in both Delete and Add since anka can't modify a rule in place.
+type portForwardingPlan struct {
+	Delete    []string
+	Add       []PortForwardingRule
+	Conflicts []string
+}
+
+func (p portForwardingPlan) empty() bool {
+	return len(p.Delete) == 0 && len(p.Add) == 0
+}
+
+type existingPortForwardingRule struct {
+	client.PortForwardingRule
+	NICIndex int
+}
+
+func existingPortForwardingRules(networkCards []client.NetworkCard) map[string]existingPortForwardingRule {
+	existing := map[string]existingPortForwardingRule{}
+	for _, networkCard := range networkCards {
+		for _, rule := range networkCard.PortForwardingRules {
+			existing[rule.RuleName] = existingPortForwardingRule{PortForwardingRule: rule, NICIndex: networkCard.Index}
+		}
+	}
+	return existing
+}
+
+// planPortForwarding compares the wanted rules with the ones on the VM's
+// network cards. Rules are matched by name; when exclusive is set, rules that
+// aren't wanted are deleted. A wanted rule whose host port is taken by a rule
+// that is kept is reported as a conflict and neither added nor, if it
+// changed, deleted.
+func planPortForwarding(networkCards []client.NetworkCard, wanted []PortForwardingRule, exclusive bool) portForwardingPlan {
+	var plan portForwardingPlan
+
+	existing := existingPortForwardingRules(networkCards)
+	wantedNames := make(map[string]struct{}, len(wanted))
+	for _, rule := range wanted {
+		wantedNames[rule.PortForwardingRuleName] = struct{}{}
+	}
+
+	// Host ports that stay in use by rules we don't manage
+	keptHostPorts := map[string]string{}
+	for name, rule := range existing {
+		if _, ok := wantedNames[name]; ok {
+			continue
+		}
+		if exclusive {
+			plan.Delete = append(plan.Delete, name)
+			continue
+		}
+		keptHostPorts[hostPortKey(rule.Protocol, rule.HostPort)] = name
+	}
+
+	for _, rule := range wanted {
+		current, exists := existing[rule.PortForwardingRuleName]
+		if exists && portForwardingRuleMatches(current, rule) {
+			continue
+		}
+		if rule.PortForwardingHostPort != 0 {
+			if owner, ok := keptHostPorts[hostPortKey(rule.PortForwardingProtocol, rule.PortForwardingHostPort)]; ok {
+				plan.Conflicts = append(plan.Conflicts, fmt.Sprintf("host port %d (%s) of rule %s is already used by rule %s",
+					rule.PortForwardingHostPort, rule.PortForwardingProtocol, rule.PortForwardingRuleName, owner))
+				continue
+			}
+		}
+		if exists {
+			plan.Delete = append(plan.Delete, rule.PortForwardingRuleName)
+		}
+		plan.Add = append(plan.Add, rule)
+	}
+
+	sort.Strings(plan.Delete)
+	return plan
+}
+
+func hostPortKey(protocol string, hostPort int) string {
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	return fmt.Sprintf("%s/%d", strings.ToLower(protocol), hostPort)
+}
+
+func portForwardingRuleMatches(current existingPortForwardingRule, wanted PortForwardingRule) bool {
+	protocol := strings.ToLower(current.Protocol)
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	if current.GuestPort != wanted.PortForwardingGuestPort || protocol != wanted.PortForwardingProtocol {
+		return false
+	}
+	if wanted.PortForwardingHostPort != 0 && current.HostPort != wanted.PortForwardingHostPort {
+		return false
+	}
+	if wanted.PortForwardingHostIP != "" && current.HostIP != wanted.PortForwardingHostIP {
+		return false
+	}
+	return current.NICIndex == wanted.PortForwardingNICIndex
+}
+
+// applyPortForwardingPlan deletes and then adds rules on the VM.
+func applyPortForwardingPlan(ankaClient *client.Client, vmName string, plan portForwardingPlan, ui packer.Ui) error {
+	for _, name := range plan.Delete {
+		ui.Say(fmt.Sprintf("Deleting %s port-forwarding rule %s", vmName, name))
+		if err := ankaClient.Modify(vmName, "delete", "port-forwarding", name); err != nil {
+			return err
+		}
+	}
+
+	for _, rule := range plan.Add {
+		ui.Say(fmt.Sprintf("Adding %s port-forwarding (Guest Port: %d, Host Port: %d, Protocol: %s, Rule Name: %s)",
+			vmName, rule.PortForwardingGuestPort, rule.PortForwardingHostPort, rule.PortForwardingProtocol, rule.PortForwardingRuleName))
+		if err := ankaClient.Modify(vmName, "add", "port-forwarding", portForwardingFlags(rule)...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func portForwardingFlags(rule PortForwardingRule) []string {
+	flags := []string{"--guest-port", strconv.Itoa(rule.PortForwardingGuestPort)}
+	if rule.PortForwardingHostPort != 0 {
+		flags = append(flags, "--host-port", strconv.Itoa(rule.PortForwardingHostPort))
+	}
+	if rule.PortForwardingHostIP != "" {
+		flags = append(flags, "--host-ip", rule.PortForwardingHostIP)
+	}
+	if rule.PortForwardingProtocol != "" {
+		flags = append(flags, "--protocol", rule.PortForwardingProtocol)
+	}
+	if rule.PortForwardingNICIndex != 0 {
+		flags = append(flags, "--nic", strconv.Itoa(rule.PortForwardingNICIndex))
+	}
+	return append(flags, rule.PortForwardingRuleName)
+}
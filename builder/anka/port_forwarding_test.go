@@ -0,0 +1,94 @@
+package anka
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+func TestPlanPortForwarding(t *testing.T) {
+	networkCards := []client.NetworkCard{
+		{
+			Index: 0,
+			PortForwardingRules: []client.PortForwardingRule{
+				{RuleName: "website", GuestPort: 80, HostPort: 12345, Protocol: "tcp"},
+				{RuleName: "ssh", GuestPort: 22, HostPort: 2222, Protocol: "tcp"},
+				{RuleName: "unmanaged", GuestPort: 9000, HostPort: 9000, Protocol: "tcp"},
+			},
+		},
+	}
+	wanted := []PortForwardingRule{
+		{PortForwardingRuleName: "website", PortForwardingGuestPort: 80, PortForwardingHostPort: 12345, PortForwardingProtocol: "tcp"},
+		{PortForwardingRuleName: "ssh", PortForwardingGuestPort: 22, PortForwardingHostPort: 2200, PortForwardingProtocol: "tcp"},
+		{PortForwardingRuleName: "dns", PortForwardingGuestPort: 53, PortForwardingHostPort: 9000, PortForwardingProtocol: "udp"},
+		{PortForwardingRuleName: "collides", PortForwardingGuestPort: 9001, PortForwardingHostPort: 9000, PortForwardingProtocol: "tcp"},
+	}
+
+	plan := planPortForwarding(networkCards, wanted, false)
+	if !reflect.DeepEqual(plan.Delete, []string{"ssh"}) {
+		t.Fatalf("Unexpected deletes: %v", plan.Delete)
+	}
+	if len(plan.Add) != 2 || plan.Add[0].PortForwardingRuleName != "ssh" || plan.Add[1].PortForwardingRuleName != "dns" {
+		t.Fatalf("Unexpected adds: %+v", plan.Add)
+	}
+	if len(plan.Conflicts) != 1 {
+		t.Fatalf("Expected one conflict, got %v", plan.Conflicts)
+	}
+
+	plan = planPortForwarding(networkCards, wanted, true)
+	if !reflect.DeepEqual(plan.Delete, []string{"ssh", "unmanaged"}) {
+		t.Fatalf("Unexpected deletes: %v", plan.Delete)
+	}
+	if len(plan.Add) != 3 || len(plan.Conflicts) != 0 {
+		t.Fatalf("Unexpected plan: %+v", plan)
+	}
+}
+
+func TestPlanPortForwardingChangedRuleConflict(t *testing.T) {
+	networkCards := []client.NetworkCard{
+		{
+			Index: 0,
+			PortForwardingRules: []client.PortForwardingRule{
+				{RuleName: "ssh", GuestPort: 22, HostPort: 2222, Protocol: "tcp"},
+				{RuleName: "unmanaged", GuestPort: 9000, HostPort: 9000, Protocol: "tcp"},
+			},
+		},
+	}
+	wanted := []PortForwardingRule{
+		{PortForwardingRuleName: "ssh", PortForwardingGuestPort: 22, PortForwardingHostPort: 9000, PortForwardingProtocol: "tcp"},
+	}
+
+	// With --force the conflict is skipped, the existing rule must be kept
+	plan := planPortForwarding(networkCards, wanted, false)
+	if len(plan.Conflicts) != 1 || !plan.empty() {
+		t.Fatalf("Expected only a conflict, got %+v", plan)
+	}
+
+	// Every changed rule that is deleted is added back
+	wanted[0].PortForwardingHostPort = 2200
+	plan = planPortForwarding(networkCards, wanted, false)
+	if !reflect.DeepEqual(plan.Delete, []string{"ssh"}) || len(plan.Add) != 1 || plan.Add[0].PortForwardingHostPort != 2200 {
+		t.Fatalf("Unexpected plan: %+v", plan)
+	}
+}
+
+func TestForwardedPorts(t *testing.T) {
+	networkCards := []client.NetworkCard{
+		{
+			Index: 0,
+			PortForwardingRules: []client.PortForwardingRule{
+				{RuleName: "website", GuestPort: 80, HostPort: 8080, Protocol: "tcp"},
+				{RuleName: "packer-ssh", GuestPort: 22, HostPort: 49152, Protocol: "tcp"},
+			},
+		},
+		{Index: 1},
+	}
+
+	if ports := forwardedPorts(networkCards); ports != "packer-ssh=49152,website=8080" {
+		t.Fatalf("Unexpected forwarded ports %q", ports)
+	}
+	if ports := forwardedPorts(nil); ports != "" {
+		t.Fatalf("Unexpected forwarded ports %q", ports)
+	}
+}
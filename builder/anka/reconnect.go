@@ -0,0 +1,126 @@
+package anka
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+// reconnectInterval is the time between checks while waiting for the guest
+// to come back.
+var reconnectInterval = 5 * time.Second
+
+// exited records that a command ended. A disconnect usually means that the
+// guest is rebooting, so the next command waits for it.
+func (c *Communicator) exited(exitCode int) {
+	if exitCode != packer.CmdDisconnect {
+		return
+	}
+	c.reconnectLock.Lock()
+	defer c.reconnectLock.Unlock()
+	c.needsReconnect = true
+}
+
+// recordBootTime remembers the boot time of the guest before the first
+// command, to tell when it went through a reboot.
+func (c *Communicator) recordBootTime(ctx context.Context) {
+	c.reconnectLock.Lock()
+	defer c.reconnectLock.Unlock()
+	if c.bootTime != "" {
+		return
+	}
+
+	bootTime, err := c.guestBootTime(ctx)
+	if err != nil {
+		log.Printf("Unable to get the boot time of VM %s: %v", c.VMName, err)
+		return
+	}
+	c.bootTime = bootTime
+}
+
+// reconnect waits for the guest to come back after a disconnect, for at most
+// reboot_timeout. A guest that still answers with the boot time from before
+// the disconnect hasn't gone down yet, as after a clean shutdown -r, so it
+// waits until the boot time changes.
+func (c *Communicator) reconnect(ctx context.Context) error {
+	c.reconnectLock.Lock()
+	defer c.reconnectLock.Unlock()
+	if !c.needsReconnect {
+		return nil
+	}
+
+	log.Printf("Waiting up to %s for VM %s to come back", c.Config.RebootTimeout, c.VMName)
+	ctx, cancel := context.WithTimeout(ctx, c.Config.RebootTimeout)
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("VM %s didn't come back within reboot_timeout (%s)", c.VMName, c.Config.RebootTimeout)
+		case <-time.After(reconnectInterval):
+		}
+
+		bootTime, err := c.guestBootTime(ctx)
+		if err != nil {
+			log.Printf("VM %s isn't ready yet: %v", c.VMName, err)
+			continue
+		}
+		if bootTime == c.bootTime {
+			log.Printf("VM %s hasn't restarted yet", c.VMName)
+			continue
+		}
+
+		log.Printf("VM %s is back", c.VMName)
+		c.bootTime = bootTime
+		c.needsReconnect = false
+		return nil
+	}
+}
+
+// guestBootTime checks that the VM is running and returns the boot time of
+// the guest.
+func (c *Communicator) guestBootTime(ctx context.Context) (string, error) {
+	show, err := c.Client.Show(c.VMName)
+	if err != nil {
+		return "", err
+	}
+	if !show.IsRunning() {
+		return "", fmt.Errorf("VM is %s", show.Status)
+	}
+
+	var stdout bytes.Buffer
+	runner := c.Client.NewRunner(client.RunParams{
+		VMName:  c.VMName,
+		Command: []string{"sysctl", "-n", "kern.boottime"},
+		Stdout:  &stdout,
+		Stderr:  ioutil.Discard,
+	})
+	if err := runner.Start(); err != nil {
+		return "", err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		err, _ := runner.Wait()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(stdout.String()), nil
+	case <-ctx.Done():
+		runner.Kill()
+		<-done
+		return "", ctx.Err()
+	}
+}
@@ -0,0 +1,118 @@
+package anka
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+// fakeSysctl prints the boot time set by the fake anka.
+const fakeSysctl = `#!/bin/sh
+echo "{ sec = $FAKE_BOOT, usec = 0 }"
+`
+
+// withFakeRebootingAnka fakes a running VM whose guest reboots between the
+// down and up runs.
+func withFakeRebootingAnka(t *testing.T, down, up int) *fakeAnka {
+	fake := withFakeAnka(t, map[string]string{
+		"show fake": `{"status": "OK", "body": {"name": "fake", "status": "running"}}`,
+	})
+	fake.command("sysctl", fakeSysctl)
+	fake.reboot(down, up)
+
+	interval := reconnectInterval
+	reconnectInterval = 10 * time.Millisecond
+	t.Cleanup(func() { reconnectInterval = interval })
+	return fake
+}
+
+// runCommands runs commands in order, checking that each exits with its
+// expected status, then that the guest was seen to restart after runs runs.
+func runCommands(t *testing.T, fake *fakeAnka, commands []string, exitStatuses []int, runs int) {
+	comm := &Communicator{
+		Config: &Config{RebootTimeout: time.Minute},
+		Client: &client.Client{},
+		VMName: "fake",
+	}
+
+	for i, command := range commands {
+		cmd := &packer.RemoteCmd{Command: command}
+		if err := cmd.RunWithUi(context.Background(), comm, packer.TestUi(t)); err != nil {
+			t.Fatalf("Unexpected error running %q: %s", command, err)
+		}
+		if cmd.ExitStatus() != exitStatuses[i] {
+			t.Fatalf("Expected exit status %d for %q, got %d", exitStatuses[i], command, cmd.ExitStatus())
+		}
+	}
+
+	if count := fake.runs(); count != runs {
+		t.Fatalf("Expected %d runs, got %d", runs, count)
+	}
+	if comm.bootTime != "{ sec = 2, usec = 0 }" {
+		t.Fatalf("Unexpected boot time %q", comm.bootTime)
+	}
+}
+
+func TestCommunicatorReconnect(t *testing.T) {
+	// The guest answers once more after the disconnect before going down
+	fake := withFakeRebootingAnka(t, 4, 6)
+
+	// The boot time, the disconnect, a check of the old boot, 2 checks while
+	// down, the check of the new boot and the command
+	runCommands(t, fake, []string{"exit 125", "true"}, []int{packer.CmdDisconnect, 0}, 7)
+}
+
+func TestCommunicatorReconnectCleanExit(t *testing.T) {
+	// The restart command exits cleanly and the guest goes down after it, so
+	// the next command disconnects, as the shell provisioner's cleanup does
+	// before it retries
+	fake := withFakeRebootingAnka(t, 3, 5)
+
+	// The boot time, the restart, the disconnect, a check while down, the
+	// check of the new boot and the retry
+	runCommands(t, fake, []string{"true", "true", "true"}, []int{0, packer.CmdDisconnect, 0}, 6)
+}
+
+func TestCommunicatorNoReconnectWithoutDisconnect(t *testing.T) {
+	fake := withFakeRebootingAnka(t, 1000, 1000)
+
+	comm := &Communicator{
+		Config: &Config{RebootTimeout: time.Minute},
+		Client: &client.Client{},
+		VMName: "fake",
+	}
+	for i := 0; i < 3; i++ {
+		cmd := &packer.RemoteCmd{Command: "true"}
+		if err := cmd.RunWithUi(context.Background(), comm, packer.TestUi(t)); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+
+	// Only the boot time is checked, once, before the first command
+	if count := fake.runs(); count != 4 {
+		t.Fatalf("Expected 4 runs, got %d", count)
+	}
+	if calls := fake.calls(); len(calls) != 1 {
+		t.Fatalf("Expected a single show, got %q", calls)
+	}
+}
+
+func TestCommunicatorReconnectTimeout(t *testing.T) {
+	withFakeRebootingAnka(t, 1, 1000)
+
+	comm := &Communicator{
+		Config:         &Config{RebootTimeout: 100 * time.Millisecond},
+		Client:         &client.Client{},
+		VMName:         "fake",
+		needsReconnect: true,
+	}
+
+	err := comm.Start(context.Background(), &packer.RemoteCmd{Command: "true"})
+	if err == nil || !strings.Contains(err.Error(), "reboot_timeout") {
+		t.Fatalf("Expected a reboot_timeout error, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package anka
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"time"
+
+	"github.com/mitchellh/go-vnc"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+const screenshotTimeout = 30 * time.Second
+
+// captureScreenshot requests the whole frame buffer from the VM's VNC server
+// and saves it as a PNG at path.
+func captureScreenshot(frameBuffer client.FrameBuffer, password string, path string) error {
+	messages := make(chan vnc.ServerMessage, 16)
+	conn, err := dialVNC(frameBuffer, password, messages)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	width, height := conn.FrameBufferWidth, conn.FrameBufferHeight
+	if err := conn.FramebufferUpdateRequest(false, 0, 0, width, height); err != nil {
+		return err
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+	remaining := int(width) * int(height)
+	timeout := time.After(screenshotTimeout)
+	for remaining > 0 {
+		select {
+		case msg := <-messages:
+			update, ok := msg.(*vnc.FramebufferUpdateMessage)
+			if !ok {
+				continue
+			}
+			for _, rect := range update.Rectangles {
+				raw, ok := rect.Enc.(*vnc.RawEncoding)
+				if !ok {
+					continue
+				}
+				drawRawRectangle(img, rect, raw, conn.PixelFormat)
+				remaining -= int(rect.Width) * int(rect.Height)
+			}
+		case <-timeout:
+			return fmt.Errorf("timed out waiting for the VNC frame buffer")
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func drawRawRectangle(img *image.RGBA, rect vnc.Rectangle, raw *vnc.RawEncoding, format vnc.PixelFormat) {
+	scale := func(value, max uint16) uint8 {
+		if max == 0 {
+			return uint8(value)
+		}
+		return uint8(uint32(value) * 255 / uint32(max))
+	}
+
+	for y := 0; y < int(rect.Height); y++ {
+		for x := 0; x < int(rect.Width); x++ {
+			c := raw.Colors[y*int(rect.Width)+x]
+			img.SetRGBA(int(rect.X)+x, int(rect.Y)+y, color.RGBA{
+				R: scale(c.R, format.RedMax),
+				G: scale(c.G, format.GreenMax),
+				B: scale(c.B, format.BlueMax),
+				A: 255,
+			})
+		}
+	}
+}
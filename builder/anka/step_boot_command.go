@@ -0,0 +1,80 @@
+package anka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/bootcommand"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+// StepBootCommand types the boot_command into the VM over VNC, for installer
+// flows such as the Setup Assistant that can't be driven through anka run.
+type StepBootCommand struct{}
+
+func (s *StepBootCommand) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+	cmdClient := state.Get("client").(*client.Client)
+	vmName := state.Get("vm_name").(string)
+
+	onError := func(err error) multistep.StepAction {
+		return stepError(ui, state, err)
+	}
+
+	if len(config.VNCConfig.BootCommand) == 0 {
+		log.Print("No boot command given, skipping")
+		return multistep.ActionContinue
+	}
+
+	if config.VNCConfig.BootWait > 0 {
+		ui.Say(fmt.Sprintf("Waiting %s for boot...", config.VNCConfig.BootWait))
+		select {
+		case <-time.After(config.VNCConfig.BootWait):
+		case <-ctx.Done():
+			return multistep.ActionHalt
+		}
+	}
+
+	describeResponse, err := cmdClient.Describe(vmName)
+	if err != nil {
+		return onError(err)
+	}
+
+	conn, err := dialVNC(describeResponse.Display.FrameBuffer, config.VNCPassword, nil)
+	if err != nil {
+		return onError(err)
+	}
+	defer conn.Close()
+
+	command, err := interpolate.Render(config.VNCConfig.FlatBootCommand(), &config.ctx)
+	if err != nil {
+		return onError(fmt.Errorf("Error preparing boot command: %s", err))
+	}
+
+	ui.Say("Typing the boot command over VNC...")
+	if err := typeBootCommand(ctx, conn, command, config.VNCConfig.BootKeyInterval); err != nil {
+		return onError(fmt.Errorf("Error running boot command: %s", err))
+	}
+
+	return multistep.ActionContinue
+}
+
+// typeBootCommand sends the key events for command to a VNC connection.
+func typeBootCommand(ctx context.Context, conn bootcommand.VNCKeyEvent, command string, keyInterval time.Duration) error {
+	seq, err := bootcommand.GenerateExpressionSequence(command)
+	if err != nil {
+		return err
+	}
+	driver := bootcommand.NewVNCDriver(conn, keyInterval)
+	return seq.Do(ctx, driver)
+}
+
+func (s *StepBootCommand) Cleanup(state multistep.StateBag) {
+	// nothing to do here!
+}
@@ -5,14 +5,14 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
-	"os"
-	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/groob/plist"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
 	"github.com/veertuinc/packer-builder-veertu-anka/client"
 	"github.com/veertuinc/packer-builder-veertu-anka/common"
 )
@@ -24,9 +24,10 @@ func init() {
 }
 
 type StepCreateVM struct {
-	client  *client.Client
-	vmName  string
-	license client.LicenseResponse
+	client       *client.Client
+	vmName       string
+	sourceVMName string
+	license      client.LicenseResponse
 }
 
 const (
@@ -35,6 +36,111 @@ const (
 	DEFAULT_CPU_COUNT = "2"
 )
 
+// What to do when the existing base VM doesn't have the requested hardware
+const (
+	baseVMMismatchReuse   = "reuse"
+	baseVMMismatchRebuild = "rebuild"
+	baseVMMismatchFail    = "fail"
+)
+
+var baseVMMismatchPolicies = map[string]struct{}{
+	baseVMMismatchReuse:   {},
+	baseVMMismatchRebuild: {},
+	baseVMMismatchFail:    {},
+}
+
+// baseVMNameData is available to the base_vm_name template.
+type baseVMNameData struct {
+	MacOSVersion     string
+	InstallerVersion string
+	Build            string
+}
+
+// baseVMName is the name of the base VM created from the installer.
+func baseVMName(config *Config, version installerAppVersion) (string, error) {
+	if config.BaseVMName == "" {
+		return fmt.Sprintf("anka-packer-base-%s", version.baseName()), nil
+	}
+
+	ctx := config.ctx
+	ctx.Data = &baseVMNameData{
+		MacOSVersion:     version.OSVersion,
+		InstallerVersion: version.ShortVersion,
+		Build:            version.Build,
+	}
+	name, err := interpolate.Render(config.BaseVMName, &ctx)
+	if err != nil {
+		return "", fmt.Errorf("Error rendering base_vm_name: %s", err)
+	}
+	if name == "" {
+		return "", fmt.Errorf("base_vm_name %q rendered an empty name", config.BaseVMName)
+	}
+	return name, nil
+}
+
+// rebuildBaseVM tells whether the existing base VM must be deleted and created
+// again from the installer, as forced by rebuild_base_vm or because its
+// hardware doesn't match the request and base_vm_mismatch says so.
+func (s *StepCreateVM) rebuildBaseVM(vmName string, fromInstaller bool, config *Config, ui packer.Ui) (bool, error) {
+	if !fromInstaller {
+		return false, nil
+	}
+	if config.RebuildBaseVM {
+		return true, nil
+	}
+
+	show, err := s.client.Show(vmName)
+	if err != nil {
+		return false, err
+	}
+	mismatches, err := baseVMMismatches(show, config)
+	if err != nil || len(mismatches) == 0 {
+		return false, err
+	}
+
+	switch config.BaseVMMismatch {
+	case baseVMMismatchRebuild:
+		ui.Say(fmt.Sprintf("Base VM %s doesn't match the requested hardware: %s", vmName, strings.Join(mismatches, ", ")))
+		return true, nil
+	case baseVMMismatchFail:
+		return false, fmt.Errorf("base VM %s doesn't match the requested hardware: %s (set base_vm_mismatch to reuse or rebuild it)", vmName, strings.Join(mismatches, ", "))
+	}
+	ui.Say(fmt.Sprintf("Reusing base VM %s although it doesn't match the requested hardware: %s", vmName, strings.Join(mismatches, ", ")))
+	return false, nil
+}
+
+// baseVMMismatches describes how the hardware of an existing base VM differs
+// from the disk_size, ram_size and cpu_count that were set.
+func baseVMMismatches(show client.ShowResponse, config *Config) ([]string, error) {
+	var mismatches []string
+
+	if config.DiskSize != "" {
+		err, diskSizeBytes := convertDiskSizeToBytes(config.DiskSize)
+		if err != nil {
+			return nil, err
+		}
+		if diskSizeBytes != show.HardDrive {
+			mismatches = append(mismatches, fmt.Sprintf("disk size is %d bytes, not %s", show.HardDrive, config.DiskSize))
+		}
+	}
+
+	if config.RAMSize != "" && config.RAMSize != show.RAM {
+		mismatches = append(mismatches, fmt.Sprintf("RAM is %s, not %s", show.RAM, config.RAMSize))
+	}
+
+	if config.CPUCount != "" {
+		cpuCount, err := strconv.Atoi(config.CPUCount)
+		if err != nil {
+			return nil, err
+		}
+		if cpuCount != show.CPUCores {
+			mismatches = append(mismatches, fmt.Sprintf("%d CPU cores, not %d", show.CPUCores, cpuCount))
+		}
+	}
+
+	return mismatches, nil
+}
+
 func (s *StepCreateVM) modifyVMResources(showResponse client.ShowResponse, config *Config, ui packer.Ui) error {
 
 	stopParams := client.StopParams{
@@ -107,45 +213,92 @@ func (s *StepCreateVM) modifyVMResources(showResponse client.ShowResponse, confi
 
 func (s *StepCreateVM) modifyVMProperties(describeResponse client.DescribeResponse, showResponse client.ShowResponse, config *Config, ui packer.Ui) error {
 
-	stopParams := client.StopParams{
-		VMName: showResponse.Name,
-		Force:  true,
+	if changes := planNetworkCards(describeResponse.NetworkCards, config.NetworkCards); len(changes) > 0 {
+		if err := s.client.Stop(client.StopParams{VMName: showResponse.Name, Force: true}); err != nil {
+			return err
+		}
+		if err := applyNetworkCardChanges(s.client, showResponse.Name, changes, ui); err != nil {
+			return err
+		}
 	}
 
-	if len(config.PortForwardingRules) > 0 {
-		// Check if the rule already exists
-		existingForwardedPorts := make(map[int]struct{}, 0)
-		for _, existingNetworkCard := range describeResponse.NetworkCards {
-			for _, existingPortForwardingRule := range existingNetworkCard.PortForwardingRules {
-				existingForwardedPorts[existingPortForwardingRule.HostPort] = struct{}{}
+	if len(config.PortForwardingRules) > 0 || config.PortForwardingRulesExclusive {
+		plan := planPortForwarding(describeResponse.NetworkCards, config.PortForwardingRules, config.PortForwardingRulesExclusive)
+		for _, conflict := range plan.Conflicts {
+			if !config.PackerConfig.PackerForce { // If force is enabled, just skip
+				return fmt.Errorf("Port-forwarding conflict: %s", conflict)
 			}
+			ui.Error(fmt.Sprintf("Port-forwarding conflict: %s! Skipping without setting...", conflict))
 		}
-		for _, wantedPortForwardingRule := range config.PortForwardingRules {
-			ui.Say(fmt.Sprintf("Ensuring %s port-forwarding (Guest Port: %s, Host Port: %s, Rule Name: %s)", showResponse.Name, strconv.Itoa(wantedPortForwardingRule.PortForwardingGuestPort), strconv.Itoa(wantedPortForwardingRule.PortForwardingHostPort), wantedPortForwardingRule.PortForwardingRuleName))
-			// Check if host port is set already and warn the user
-			if _, ok := existingForwardedPorts[wantedPortForwardingRule.PortForwardingHostPort]; ok {
-				ui.Error(fmt.Sprintf("Found an existing host port rule (%s)! Skipping without setting...", strconv.Itoa(wantedPortForwardingRule.PortForwardingHostPort)))
-				continue
+		if !plan.empty() {
+			if err := s.client.Stop(client.StopParams{VMName: showResponse.Name, Force: true}); err != nil {
+				return err
 			}
-			if err := s.client.Stop(stopParams); err != nil {
+			if err := applyPortForwardingPlan(s.client, showResponse.Name, plan, ui); err != nil {
 				return err
 			}
-			err := s.client.Modify(showResponse.Name, "add", "port-forwarding", "--host-port", strconv.Itoa(wantedPortForwardingRule.PortForwardingHostPort), "--guest-port", strconv.Itoa(wantedPortForwardingRule.PortForwardingGuestPort), wantedPortForwardingRule.PortForwardingRuleName)
-			if config.PackerConfig.PackerForce == false { // If force is enabled, just skip
-				if err != nil {
-					return err
-				}
+		}
+	}
+
+	if err := s.modifyCustomVariables(describeResponse, config, ui); err != nil {
+		return err
+	}
+
+	if modifications := planDisplay(describeResponse.Display, config); len(modifications) > 0 {
+		if err := s.client.Stop(client.StopParams{VMName: showResponse.Name, Force: true}); err != nil {
+			return err
+		}
+		for _, modification := range modifications {
+			ui.Say(fmt.Sprintf("Modifying VM %s %s settings", showResponse.Name, modification[0]))
+			if err := s.client.Modify(showResponse.Name, "set", modification[0], modification[1:]...); err != nil {
+				return err
 			}
 		}
 	}
 
-	if config.HWUUID != "" {
-		if err := s.client.Stop(stopParams); err != nil {
+	return nil
+}
+
+// modifyCustomVariables sets the requested custom variables that differ from
+// the ones already on the VM and, with custom_variables_exclusive, deletes the
+// ones that were not requested.
+func (s *StepCreateVM) modifyCustomVariables(describeResponse client.DescribeResponse, config *Config, ui packer.Ui) error {
+	var toSet, toDelete []string
+
+	for key, value := range config.CustomVariables {
+		if existing, ok := describeResponse.CustomVariables[key]; !ok || existing != value {
+			toSet = append(toSet, key)
+		}
+	}
+	if config.CustomVariablesExclusive {
+		for key := range describeResponse.CustomVariables {
+			if _, ok := config.CustomVariables[key]; !ok {
+				toDelete = append(toDelete, key)
+			}
+		}
+	}
+
+	if len(toSet) == 0 && len(toDelete) == 0 {
+		log.Print("Custom variables already up to date")
+		return nil
+	}
+	sort.Strings(toSet)
+	sort.Strings(toDelete)
+
+	if err := s.client.Stop(client.StopParams{VMName: describeResponse.Name, Force: true}); err != nil {
+		return err
+	}
+
+	for _, key := range toSet {
+		ui.Say(fmt.Sprintf("Modifying VM custom-variable %s to %s", key, config.CustomVariables[key]))
+		if err := s.client.Modify(describeResponse.Name, "set", "custom-variable", key, config.CustomVariables[key]); err != nil {
 			return err
 		}
-		ui.Say(fmt.Sprintf("Modifying VM custom-variable hw.UUID to %s", config.HWUUID))
-		err := s.client.Modify(showResponse.Name, "set", "custom-variable", "hw.UUID", config.HWUUID)
-		if err != nil {
+	}
+
+	for _, key := range toDelete {
+		ui.Say(fmt.Sprintf("Deleting VM custom-variable %s", key))
+		if err := s.client.Modify(describeResponse.Name, "delete", "custom-variable", key); err != nil {
 			return err
 		}
 	}
@@ -165,28 +318,69 @@ func (s *StepCreateVM) Run(ctx context.Context, state multistep.StateBag) multis
 	}
 
 	createSourceVM := false
-	installerAppFullName := "anka-packer-base"
+	var installerAppFullName string
+
+	installerApp := config.InstallerApp
+	var installerVersion installerAppVersion
+	if config.InstallerVersion != "" {
+		ui.Say(fmt.Sprintf("Looking for an installer app matching %q in %s", config.InstallerVersion, strings.Join(config.InstallerSearchPaths, ", ")))
+		var err error
+		installerApp, installerVersion, err = findInstallerApp(config.InstallerSearchPaths, config.InstallerVersion)
+		if err != nil {
+			return onError(err)
+		}
+		ui.Say(fmt.Sprintf("Using installer app %q, version %s", installerApp, installerVersion.ShortVersion))
+	} else if installerApp != "" {
+		ui.Say(fmt.Sprintf("Extracting version from installer app: %q", installerApp))
+		var err error
+		installerVersion, err = obtainMacOSVersionFromInstallerApp(installerApp) // Grab the version details from the Info.plist inside of the Installer package
+		if err != nil {
+			return onError(err)
+		}
+	}
 
-	if config.InstallerApp != "" { // If users specifies an InstallerApp and sourceVMName doesn't exist, assume they want to build a new VM template and use the macOS installer version
+	if installerApp != "" { // If users specifies an InstallerApp and sourceVMName doesn't exist, assume they want to build a new VM template and use the macOS installer version
 		createSourceVM = true
-		ui.Say(fmt.Sprintf("Extracting version from installer app: %q", config.InstallerApp))
-		macOSVersionFromInstallerApp, err := obtainMacOSVersionFromInstallerApp(config.InstallerApp) // Grab the version details from the Info.plist inside of the Installer package
+		log.Printf("Installer app version: %+v", installerVersion)
+		name, err := baseVMName(config, installerVersion) // We need to set the SourceVMName since the user didn't and the logic below creates a VM using it
 		if err != nil {
 			return onError(err)
 		}
-		installerAppFullName = fmt.Sprintf("%s-%s", installerAppFullName, macOSVersionFromInstallerApp) // We need to set the SourceVMName since the user didn't and the logic below creates a VM using it
+		installerAppFullName = name
+		state.Put("installer_app", installerApp)
+		state.Put("installer_version", installerVersion.ShortVersion)
 	}
 
 	if sourceVMName == "" {
 		sourceVMName = installerAppFullName
 	}
 
+	// Other builds on this host may be creating, stopping or cloning the same
+	// source VM
+	lock, err := lockVM(ctx, sourceVMName, config.LockTimeout, ui)
+	if err != nil {
+		return onError(err)
+	}
+	defer lock.Unlock()
+	s.sourceVMName = sourceVMName
+
 	// Reuse the base VM template if it matches the one from the installer
 	if sourceVMExists, err := s.client.Exists(sourceVMName); err != nil {
 		return onError(err)
 	} else {
 		if sourceVMExists {
-			createSourceVM = false
+			rebuild, err := s.rebuildBaseVM(sourceVMName, createSourceVM, config, ui)
+			if err != nil {
+				return onError(err)
+			}
+			if rebuild {
+				ui.Say(fmt.Sprintf("Deleting base VM %s to rebuild it", sourceVMName))
+				if err := s.client.Delete(client.DeleteParams{VMName: sourceVMName}); err != nil {
+					return onError(err)
+				}
+			} else {
+				createSourceVM = false
+			}
 		}
 	}
 
@@ -213,7 +407,7 @@ func (s *StepCreateVM) Run(ctx context.Context, state multistep.StateBag) multis
 			}
 		}()
 		createParams := client.CreateParams{
-			InstallerApp: config.InstallerApp,
+			InstallerApp: installerApp,
 			Name:         sourceVMName,
 			DiskSize:     config.DiskSize,
 			CPUCount:     config.CPUCount,
@@ -244,6 +438,9 @@ func (s *StepCreateVM) Run(ctx context.Context, state multistep.StateBag) multis
 	if err != nil {
 		return onError(err)
 	}
+	// The source may be a base VM created from the installer
+	state.Put("source_vm_name", show.Name)
+	state.Put("source_vm_id", show.UUID)
 
 	if show.IsRunning() {
 		if s.license.LicenseType == "com.veertu.anka.develop" {
@@ -277,6 +474,7 @@ func (s *StepCreateVM) Run(ctx context.Context, state multistep.StateBag) multis
 	if err = s.client.Clone(client.CloneParams{VMName: clonedVMName, SourceUUID: show.UUID}); err != nil {
 		return onError(err)
 	}
+	lock.Unlock()
 
 	showResponse, err := s.client.Show(clonedVMName)
 	if err != nil {
@@ -311,6 +509,17 @@ func (s *StepCreateVM) Cleanup(state multistep.StateBag) {
 		return
 	}
 
+	// The build failed before cloning, so the source VM is being cleaned up
+	if s.vmName == s.sourceVMName {
+		config := state.Get("config").(*Config)
+		lock, err := lockVM(context.Background(), s.vmName, config.LockTimeout, ui)
+		if err != nil {
+			ui.Error(fmt.Sprint(err))
+			return
+		}
+		defer lock.Unlock()
+	}
+
 	_, halted := state.GetOk(multistep.StateHalted)
 	_, canceled := state.GetOk(multistep.StateCancelled)
 	errorObj := state.Get("error")
@@ -361,31 +570,3 @@ func randSeq(n int) string {
 	}
 	return string(b)
 }
-
-func obtainMacOSVersionFromInstallerApp(path string) (string, error) {
-	_, err := os.Stat(path)
-	if os.IsNotExist(err) {
-		return "", fmt.Errorf("installer app does not exist at %q: %w", path, err)
-	}
-	if err != nil {
-		return "", fmt.Errorf("failed to stat installer at %q: %w", path, err)
-	}
-
-	plistPath := filepath.Join(path, "Contents", "Info.plist")
-	_, err = os.Stat(plistPath)
-	if os.IsNotExist(err) {
-		return "", fmt.Errorf("installer app info plist did not exist at %q: %w", plistPath, err)
-	}
-	if err != nil {
-		return "", fmt.Errorf("failed to stat installer app info plist at %q: %w", plistPath, err)
-	}
-	plistContent, err := os.Open(plistPath)
-
-	var installAppPlist struct {
-		PlatformVersion string `plist:"DTPlatformVersion"`
-		ShortVersion    string `plist:"CFBundleShortVersionString"`
-	}
-	plist.NewXMLDecoder(plistContent).Decode(&installAppPlist)
-
-	return fmt.Sprintf("%s-%s", installAppPlist.PlatformVersion, installAppPlist.ShortVersion), nil
-}
@@ -0,0 +1,58 @@
+package anka
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+func TestBaseVMName(t *testing.T) {
+	version := installerAppVersion{OSVersion: "GM", ShortVersion: "16.4.06", Build: "20D64"}
+
+	c := testConfig()
+	config, err := NewConfig(c)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if name, err := baseVMName(config, version); err != nil || name != "anka-packer-base-GM-16.4.06-20D64" {
+		t.Fatalf("Unexpected default base VM name %q (%v)", name, err)
+	}
+
+	c["base_vm_name"] = "big-sur-{{ .InstallerVersion }}-{{ .Build }}"
+	config, err = NewConfig(c)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if name, err := baseVMName(config, version); err != nil || name != "big-sur-16.4.06-20D64" {
+		t.Fatalf("Unexpected base VM name %q (%v)", name, err)
+	}
+}
+
+func TestBaseVMMismatches(t *testing.T) {
+	show := client.ShowResponse{CPUCores: 2, RAM: "4G", HardDrive: 42949672960}
+
+	config := &Config{}
+	if mismatches, err := baseVMMismatches(show, config); err != nil || len(mismatches) != 0 {
+		t.Fatalf("Unexpected mismatches without a request: %v (%v)", mismatches, err)
+	}
+
+	config = &Config{DiskSize: "40G", RAMSize: "4G", CPUCount: "2"}
+	if mismatches, err := baseVMMismatches(show, config); err != nil || len(mismatches) != 0 {
+		t.Fatalf("Unexpected mismatches: %v (%v)", mismatches, err)
+	}
+
+	config = &Config{DiskSize: "80G", RAMSize: "8G", CPUCount: "4"}
+	mismatches, err := baseVMMismatches(show, config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := []string{
+		"disk size is 42949672960 bytes, not 80G",
+		"RAM is 4G, not 8G",
+		"2 CPU cores, not 4",
+	}
+	if !reflect.DeepEqual(mismatches, expected) {
+		t.Fatalf("Unexpected mismatches %q", mismatches)
+	}
+}
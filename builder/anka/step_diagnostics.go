@@ -0,0 +1,85 @@
+package anka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+// StepDiagnostics collects diagnostics about the VM when a later step fails.
+// Its cleanup runs before StepCreateVM deletes the VM. Diagnostics are only
+// collected once, by the latest StepDiagnostics that ran.
+type StepDiagnostics struct{}
+
+func (s *StepDiagnostics) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	return multistep.ActionContinue
+}
+
+func (s *StepDiagnostics) Cleanup(state multistep.StateBag) {
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+	cmdClient := state.Get("client").(*client.Client)
+	vmName := state.Get("vm_name").(string)
+
+	rawErr, failed := state.GetOk("error")
+	if !failed || config.DiagnosticsDir == "" {
+		return
+	}
+	if _, ok := state.GetOk("diagnostics_collected"); ok {
+		return
+	}
+	state.Put("diagnostics_collected", true)
+
+	if err := os.MkdirAll(config.DiagnosticsDir, 0755); err != nil {
+		ui.Error(fmt.Sprintf("Error creating diagnostics directory: %s", err))
+		return
+	}
+	prefix := filepath.Join(config.DiagnosticsDir, fmt.Sprintf("%s-%s", vmName, time.Now().Format("20060102-150405")))
+
+	var collected []string
+
+	ui.Say("Capturing a screenshot of the VM...")
+	screenshotPath := prefix + ".png"
+	if err := s.captureScreenshot(cmdClient, vmName, config, screenshotPath); err != nil {
+		ui.Error(fmt.Sprintf("Error capturing a screenshot of the VM: %s", err))
+	} else {
+		collected = append(collected, fmt.Sprintf("screenshot saved to %s", screenshotPath))
+	}
+
+	bundlePath := prefix + ".tar.gz"
+	ui.Say("Collecting diagnostics bundle...")
+	var comm packer.Communicator
+	if raw, ok := state.GetOk("communicator"); ok {
+		comm, _ = raw.(packer.Communicator)
+	}
+	if err := writeDiagnosticsBundle(bundlePath, vmName, cmdClient, comm, config.OnErrorCollect); err != nil {
+		ui.Error(fmt.Sprintf("Error collecting diagnostics bundle: %s", err))
+	} else {
+		collected = append(collected, fmt.Sprintf("diagnostics bundle saved to %s", bundlePath))
+	}
+
+	if len(collected) > 0 {
+		ui.Say(fmt.Sprintf("Diagnostics: %s", strings.Join(collected, ", ")))
+		state.Put("error", fmt.Errorf("%w (%s)", rawErr.(error), strings.Join(collected, ", ")))
+	}
+}
+
+func (s *StepDiagnostics) captureScreenshot(cmdClient *client.Client, vmName string, config *Config, path string) error {
+	describeResponse, err := cmdClient.Describe(vmName)
+	if err != nil {
+		return err
+	}
+	if err := captureScreenshot(describeResponse.Display.FrameBuffer, config.VNCPassword, path); err != nil {
+		return err
+	}
+	log.Printf("Saved screenshot of %s to %s", vmName, path)
+	return nil
+}
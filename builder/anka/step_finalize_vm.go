@@ -0,0 +1,84 @@
+package anka
+
+import (
+	"context"
+	"log"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+// StepFinalizeVM removes build-only configuration from the VM once
+// provisioning is done, before it gets suspended.
+type StepFinalizeVM struct{}
+
+func (s *StepFinalizeVM) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+	cmdClient := state.Get("client").(*client.Client)
+	vmName := state.Get("vm_name").(string)
+
+	onError := func(err error) multistep.StepAction {
+		return stepError(ui, state, err)
+	}
+
+	// Provisioners may have updated macOS. Recorded in the artifact; a build
+	// doesn't fail without it
+	if info, err := readGuestInfo(cmdClient, vmName); err == nil {
+		state.Put("macos_version", info.ProductVersion)
+		state.Put("macos_build", info.BuildVersion)
+		state.Put("macos_arch", info.Arch)
+	} else {
+		log.Printf("Unable to get the macOS version of VM %s: %v", vmName, err)
+	}
+
+	describeResponse, err := cmdClient.Describe(vmName)
+	if err != nil {
+		return onError(err)
+	}
+
+	portForwardingPlan := temporaryPortForwardingPlan(describeResponse.NetworkCards, config.PortForwardingRules)
+	networkChanges := planFinalNetworkModes(describeResponse.NetworkCards, config.NetworkCards)
+	if portForwardingPlan.empty() && len(networkChanges) == 0 {
+		log.Print("No temporary port-forwarding rules to remove or final network card changes")
+		return multistep.ActionContinue
+	}
+
+	// Port-forwarding rules and network cards are only modified while the VM
+	// is stopped, as when the VM is created. The VM is started again so that
+	// it can be suspended on cleanup.
+	ui.Say("Stopping VM to remove temporary port-forwarding rules and apply final network card modes")
+	if err := cmdClient.Stop(client.StopParams{VMName: vmName}); err != nil {
+		return onError(err)
+	}
+	if err := applyPortForwardingPlan(cmdClient, vmName, portForwardingPlan, ui); err != nil {
+		return onError(err)
+	}
+	if err := applyNetworkCardChanges(cmdClient, vmName, networkChanges, ui); err != nil {
+		return onError(err)
+	}
+	if err := cmdClient.Start(client.StartParams{VMName: vmName}); err != nil {
+		return onError(err)
+	}
+
+	return multistep.ActionContinue
+}
+
+// temporaryPortForwardingPlan deletes the temporary rules that are on the
+// VM's network cards.
+func temporaryPortForwardingPlan(networkCards []client.NetworkCard, rules []PortForwardingRule) portForwardingPlan {
+	existing := existingPortForwardingRules(networkCards)
+
+	var plan portForwardingPlan
+	for _, rule := range rules {
+		if _, ok := existing[rule.PortForwardingRuleName]; ok && rule.PortForwardingTemporary {
+			plan.Delete = append(plan.Delete, rule.PortForwardingRuleName)
+		}
+	}
+	return plan
+}
+
+func (s *StepFinalizeVM) Cleanup(state multistep.StateBag) {
+	// nothing to do here!
+}
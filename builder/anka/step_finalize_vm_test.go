@@ -0,0 +1,43 @@
+package anka
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+func TestStepFinalizeVMStopsToRemoveTemporaryPortForwarding(t *testing.T) {
+	fake := withFakeAnka(t, map[string]string{
+		"describe fake": `{"status": "OK", "body": {"name": "fake", "network_cards": [{"index": 0, "mode": "shared", "port_forwarding_rules": [{"guest_port": 80, "rule_name": "web", "protocol": "tcp", "host_port": 8080}]}]}}`,
+	})
+
+	config := &Config{PortForwardingRules: []PortForwardingRule{
+		{PortForwardingRuleName: "web", PortForwardingGuestPort: 80, PortForwardingTemporary: true},
+	}}
+	state := new(multistep.BasicStateBag)
+	state.Put("config", config)
+	state.Put("ui", packer.TestUi(t))
+	state.Put("client", &client.Client{})
+	state.Put("vm_name", "fake")
+
+	step := &StepFinalizeVM{}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Unexpected action %v: %v", action, state.Get("error"))
+	}
+
+	var modifications []string
+	for _, call := range fake.calls() {
+		if !strings.HasPrefix(call, "describe") {
+			modifications = append(modifications, call)
+		}
+	}
+	expected := []string{"stop fake", "modify fake delete port-forwarding web", "start fake"}
+	if !reflect.DeepEqual(modifications, expected) {
+		t.Fatalf("Unexpected commands %q", fake.calls())
+	}
+}
@@ -0,0 +1,142 @@
+package anka
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+const sshPortForwardingRuleName = "packer-ssh"
+
+// StepForwardSSH makes the guest's SSH server reachable for the ssh
+// communicator: directly on the VM's IP when its first network card is
+// bridged, otherwise through a temporary port-forwarding rule on a host port
+// picked by anka. Nothing is done when ssh_host is set.
+type StepForwardSSH struct {
+	ruleAdded bool
+}
+
+func (s *StepForwardSSH) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	if config.Comm.Type != "ssh" || config.Comm.SSHHost != "" {
+		return multistep.ActionContinue
+	}
+
+	ui := state.Get("ui").(packer.Ui)
+	cmdClient := state.Get("client").(*client.Client)
+	vmName := state.Get("vm_name").(string)
+
+	onError := func(err error) multistep.StepAction {
+		return stepError(ui, state, err)
+	}
+
+	describeResponse, err := cmdClient.Describe(vmName)
+	if err != nil {
+		return onError(err)
+	}
+
+	if len(describeResponse.NetworkCards) > 0 && sameNetworkMode(describeResponse.NetworkCards[0].Mode, "bridged") {
+		log.Printf("VM %s is bridged, connecting to its IP", vmName)
+		state.Put("ssh_bridged", true)
+		return multistep.ActionContinue
+	}
+
+	// Without a host port, anka picks a free one, so concurrent builds
+	// can't race for the same port
+	plan := portForwardingPlan{
+		Add: []PortForwardingRule{{
+			PortForwardingGuestPort: config.Comm.SSHPort,
+			PortForwardingHostIP:    "127.0.0.1",
+			PortForwardingProtocol:  "tcp",
+			PortForwardingRuleName:  sshPortForwardingRuleName,
+		}},
+	}
+	// A rule left behind by an interrupted build
+	if _, ok := existingPortForwardingRules(describeResponse.NetworkCards)[sshPortForwardingRuleName]; ok {
+		plan.Delete = []string{sshPortForwardingRuleName}
+	}
+	if err := applyPortForwardingPlan(cmdClient, vmName, plan, ui); err != nil {
+		return onError(err)
+	}
+	s.ruleAdded = true
+
+	describeResponse, err = cmdClient.Describe(vmName)
+	if err != nil {
+		return onError(err)
+	}
+	rule, ok := existingPortForwardingRules(describeResponse.NetworkCards)[sshPortForwardingRuleName]
+	if !ok || rule.HostPort == 0 {
+		return onError(fmt.Errorf("anka didn't assign a host port to port-forwarding rule %s", sshPortForwardingRuleName))
+	}
+	hostPort := rule.HostPort
+
+	state.Put("ssh_host", "127.0.0.1")
+	state.Put("ssh_host_port", hostPort)
+	return multistep.ActionContinue
+}
+
+func (s *StepForwardSSH) Cleanup(state multistep.StateBag) {
+	if !s.ruleAdded {
+		return
+	}
+
+	ui := state.Get("ui").(packer.Ui)
+	cmdClient := state.Get("client").(*client.Client)
+	vmName := state.Get("vm_name").(string)
+
+	ui.Say(fmt.Sprintf("Deleting %s port-forwarding rule %s", vmName, sshPortForwardingRuleName))
+	if err := cmdClient.Modify(vmName, "delete", "port-forwarding", sshPortForwardingRuleName); err != nil {
+		ui.Error(fmt.Sprintf("Failed to delete port-forwarding rule %s: %s", sshPortForwardingRuleName, err))
+	}
+}
+
+// sshHost returns the address the ssh communicator connects to: ssh_host
+// when set, otherwise the forwarded port's or the bridged VM's. The IP of a
+// bridged VM is only known once the guest has one, so it is looked up on
+// every attempt.
+func sshHost(state multistep.StateBag) (string, error) {
+	if host := state.Get("config").(*Config).Comm.SSHHost; host != "" {
+		return host, nil
+	}
+	if host, ok := state.GetOk("ssh_host"); ok {
+		return host.(string), nil
+	}
+	if _, ok := state.GetOk("ssh_bridged"); !ok {
+		return "", errors.New("No host implemented for anka builder (which is ok)")
+	}
+
+	cmdClient := state.Get("client").(*client.Client)
+	vmName := state.Get("vm_name").(string)
+
+	var stdout, stderr bytes.Buffer
+	err, _ := cmdClient.Run(client.RunParams{
+		VMName:  vmName,
+		Command: []string{"ipconfig", "getifaddr", "en0"},
+		Stdout:  &stdout,
+		Stderr:  &stderr,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get the IP of VM %s: %w (%s)", vmName, err, strings.TrimSpace(stderr.String()))
+	}
+	ip := strings.TrimSpace(stdout.String())
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("VM %s has no IP yet", vmName)
+	}
+	return ip, nil
+}
+
+// sshPort returns the port the ssh communicator connects to.
+func sshPort(state multistep.StateBag) (int, error) {
+	if port, ok := state.GetOk("ssh_host_port"); ok {
+		return port.(int), nil
+	}
+	return state.Get("config").(*Config).Comm.SSHPort, nil
+}
@@ -0,0 +1,90 @@
+package anka
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+func TestSSHAddress(t *testing.T) {
+	c := testConfig()
+	c["communicator"] = "ssh"
+	c["ssh_username"] = "anka"
+	config, err := NewConfig(c)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if config.Comm.SSHTimeout == 0 {
+		t.Fatal("Expected a default ssh_timeout")
+	}
+
+	state := new(multistep.BasicStateBag)
+	state.Put("config", config)
+
+	if _, err := sshHost(state); err == nil {
+		t.Fatal("Expected an error without a forwarded port or bridged VM")
+	}
+	if port, err := sshPort(state); err != nil || port != 22 {
+		t.Fatalf("Unexpected port %d: %v", port, err)
+	}
+
+	port := 10022
+	state.Put("ssh_host", "127.0.0.1")
+	state.Put("ssh_host_port", port)
+
+	if host, err := sshHost(state); err != nil || host != "127.0.0.1" {
+		t.Fatalf("Unexpected host %q: %v", host, err)
+	}
+	if forwarded, err := sshPort(state); err != nil || forwarded != port {
+		t.Fatalf("Unexpected port %d: %v", forwarded, err)
+	}
+
+	// A configured ssh_host wins over the forwarded port's
+	config.Comm.SSHHost = "build-vm.example.com"
+	if host, err := sshHost(state); err != nil || host != "build-vm.example.com" {
+		t.Fatalf("Unexpected host %q: %v", host, err)
+	}
+}
+
+func TestStepForwardSSH(t *testing.T) {
+	// The describe after adding the rule shows the host port anka picked;
+	// before, it shows the same rule left behind by an interrupted build
+	fake := withFakeAnka(t, map[string]string{
+		"describe fake": `{"status": "OK", "body": {"name": "fake", "network_cards": [{"index": 0, "mode": "shared", "port_forwarding_rules": [{"guest_port": 22, "rule_name": "packer-ssh", "protocol": "tcp", "host_ip": "127.0.0.1", "host_port": 10022}]}]}}`,
+	})
+
+	c := testConfig()
+	c["communicator"] = "ssh"
+	c["ssh_username"] = "anka"
+	config, err := NewConfig(c)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	state := new(multistep.BasicStateBag)
+	state.Put("config", config)
+	state.Put("ui", packer.TestUi(t))
+	state.Put("client", &client.Client{})
+	state.Put("vm_name", "fake")
+
+	step := &StepForwardSSH{}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Unexpected action %v: %v", action, state.Get("error"))
+	}
+	expected := []string{
+		"describe fake",
+		"modify fake delete port-forwarding packer-ssh",
+		"modify fake add port-forwarding --guest-port 22 --host-ip 127.0.0.1 --protocol tcp packer-ssh",
+		"describe fake",
+	}
+	if !reflect.DeepEqual(fake.calls(), expected) {
+		t.Fatalf("Unexpected commands %q", fake.calls())
+	}
+	if port, err := sshPort(state); err != nil || port != 10022 {
+		t.Fatalf("Unexpected port %d: %v", port, err)
+	}
+}
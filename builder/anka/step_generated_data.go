@@ -0,0 +1,72 @@
+package anka
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+// generatedDataNames are the builder-generated values provisioners and
+// post-processors can use, as build.VMName etc.
+var generatedDataNames = []string{
+	"VMName",
+	"VMUUID",
+	"SourceVMName",
+	"SourceVMUUID",
+	"AnkaVersion",
+	"MacOSVersion",
+	"ForwardedPorts",
+}
+
+// StepGeneratedData publishes what is known about the VM once it is up, for
+// the provisioners.
+type StepGeneratedData struct{}
+
+func (s *StepGeneratedData) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packer.Ui)
+	cmdClient := state.Get("client").(*client.Client)
+	vmName := state.Get("vm_name").(string)
+
+	onError := func(err error) multistep.StepAction {
+		return stepError(ui, state, err)
+	}
+
+	describeResponse, err := cmdClient.Describe(vmName)
+	if err != nil {
+		return onError(err)
+	}
+
+	generatedData := &packerbuilderdata.GeneratedData{State: state}
+	generatedData.Put("VMName", describeResponse.Name)
+	generatedData.Put("VMUUID", describeResponse.UUID)
+	// The source resolved by StepCreateVM, which is the base VM when
+	// building from an installer
+	generatedData.Put("SourceVMName", state.Get("source_vm_name").(string))
+	generatedData.Put("SourceVMUUID", state.Get("source_vm_id").(string))
+	generatedData.Put("AnkaVersion", state.Get("anka_version").(string))
+	generatedData.Put("ForwardedPorts", forwardedPorts(describeResponse.NetworkCards))
+	generatedData.Put("MacOSVersion", state.Get("macos_version").(string))
+
+	return multistep.ActionContinue
+}
+
+func (s *StepGeneratedData) Cleanup(state multistep.StateBag) {
+	// nothing to do here!
+}
+
+// forwardedPorts lists the port-forwarding rules of the VM as
+// name=host_port, sorted by name and separated by commas.
+func forwardedPorts(networkCards []client.NetworkCard) string {
+	var ports []string
+	for name, rule := range existingPortForwardingRules(networkCards) {
+		ports = append(ports, fmt.Sprintf("%s=%d", name, rule.HostPort))
+	}
+	sort.Strings(ports)
+	return strings.Join(ports, ",")
+}
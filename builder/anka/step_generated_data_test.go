@@ -0,0 +1,52 @@
+package anka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+func TestStepGeneratedData(t *testing.T) {
+	withFakeAnka(t, map[string]string{
+		"describe anka-packer-abc": `{"status": "OK", "body": {"name": "anka-packer-abc", "uuid": "vm-id", "network_cards": [{"index": 0, "port_forwarding_rules": [{"rule_name": "packer-ssh", "guest_port": 22, "host_port": 50022}]}]}}`,
+	})
+
+	// Built from an installer, so there is no source_vm_name in the config
+	config, err := NewConfig(testConfig())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	state := new(multistep.BasicStateBag)
+	state.Put("config", config)
+	state.Put("ui", packer.TestUi(t))
+	state.Put("client", &client.Client{})
+	state.Put("vm_name", "anka-packer-abc")
+	state.Put("source_vm_name", "anka-packer-base-GM-16.4.06-20D64")
+	state.Put("source_vm_id", "base-id")
+	state.Put("anka_version", "2.3.2")
+	state.Put("macos_version", "11.2.3")
+
+	if action := (&StepGeneratedData{}).Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("Unexpected action %v: %v", action, state.Get("error"))
+	}
+
+	generatedData := state.Get("generated_data").(map[string]interface{})
+	expected := map[string]string{
+		"VMName":         "anka-packer-abc",
+		"VMUUID":         "vm-id",
+		"SourceVMName":   "anka-packer-base-GM-16.4.06-20D64",
+		"SourceVMUUID":   "base-id",
+		"AnkaVersion":    "2.3.2",
+		"MacOSVersion":   "11.2.3",
+		"ForwardedPorts": "packer-ssh=50022",
+	}
+	for key, value := range expected {
+		if generatedData[key] != value {
+			t.Errorf("Unexpected %s %q", key, generatedData[key])
+		}
+	}
+}
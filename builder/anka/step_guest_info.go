@@ -0,0 +1,112 @@
+package anka
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+// guestInfo is what the guest reports about its macOS.
+type guestInfo struct {
+	ProductVersion string
+	BuildVersion   string
+	Arch           string
+}
+
+// StepGuestInfo reads the macOS version, build and architecture of the guest
+// once it is up, and checks expected_macos_version.
+type StepGuestInfo struct{}
+
+func (s *StepGuestInfo) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packer.Ui)
+	cmdClient := state.Get("client").(*client.Client)
+	vmName := state.Get("vm_name").(string)
+
+	onError := func(err error) multistep.StepAction {
+		return stepError(ui, state, err)
+	}
+
+	info, err := readGuestInfo(cmdClient, vmName)
+	if err != nil {
+		return onError(fmt.Errorf("unable to read the macOS version of the guest: %w", err))
+	}
+	ui.Say(fmt.Sprintf("Guest is running macOS %s (%s) on %s", info.ProductVersion, info.BuildVersion, info.Arch))
+
+	state.Put("macos_version", info.ProductVersion)
+	state.Put("macos_build", info.BuildVersion)
+	state.Put("macos_arch", info.Arch)
+
+	if config.ExpectedMacOSVersion != "" {
+		if err := checkMacOSVersion(info.ProductVersion, config.ExpectedMacOSVersion); err != nil {
+			return onError(err)
+		}
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *StepGuestInfo) Cleanup(state multistep.StateBag) {
+	// nothing to do here!
+}
+
+func readGuestInfo(cmdClient *client.Client, vmName string) (guestInfo, error) {
+	var stdout bytes.Buffer
+	err, _ := cmdClient.Run(client.RunParams{
+		VMName: vmName,
+		Script: "sw_vers && echo \"Arch: $(sysctl -n hw.machine)\"",
+		Stdout: &stdout,
+	})
+	if err != nil {
+		return guestInfo{}, err
+	}
+	return parseGuestInfo(stdout.String())
+}
+
+// parseGuestInfo parses the output of sw_vers, followed by an Arch line.
+func parseGuestInfo(output string) (guestInfo, error) {
+	var info guestInfo
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "ProductVersion":
+			info.ProductVersion = value
+		case "BuildVersion":
+			info.BuildVersion = value
+		case "Arch":
+			info.Arch = value
+		}
+	}
+	if info.ProductVersion == "" {
+		return info, fmt.Errorf("no ProductVersion in %q", output)
+	}
+	return info, nil
+}
+
+// checkMacOSVersion fails unless productVersion satisfies the constraint.
+func checkMacOSVersion(productVersion string, constraint string) error {
+	constraints, err := version.NewConstraint(constraint)
+	if err != nil {
+		return err
+	}
+	v, err := version.NewVersion(productVersion)
+	if err != nil {
+		return fmt.Errorf("unable to parse the guest's macOS version %q: %w", productVersion, err)
+	}
+	if !constraints.Check(v) {
+		return fmt.Errorf("guest is running macOS %s, expected_macos_version is %q", productVersion, constraint)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package anka
+
+import (
+	"testing"
+)
+
+func TestParseGuestInfo(t *testing.T) {
+	output := "ProductName:\tmacOS\nProductVersion:\t11.2.3\nBuildVersion:\t20D91\nArch: arm64\n"
+
+	info, err := parseGuestInfo(output)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if info != (guestInfo{ProductVersion: "11.2.3", BuildVersion: "20D91", Arch: "arm64"}) {
+		t.Fatalf("Unexpected guest info %+v", info)
+	}
+
+	if _, err := parseGuestInfo("Arch: x86_64\n"); err == nil {
+		t.Fatalf("Expected an error without a product version")
+	}
+}
+
+func TestCheckMacOSVersion(t *testing.T) {
+	cases := []struct {
+		version    string
+		constraint string
+		ok         bool
+	}{
+		{"11.2.3", ">= 11.2", true},
+		{"11.2", ">= 11.2", true},
+		{"10.15.7", ">= 11.2", false},
+		{"11.2.3", "~> 11.2.0", true},
+		{"11.3", "~> 11.2.0", false},
+		{"11.2.3", "= 11.2.3", true},
+	}
+
+	for _, c := range cases {
+		err := checkMacOSVersion(c.version, c.constraint)
+		if (err == nil) != c.ok {
+			t.Errorf("macOS %s with %q: unexpected result %v", c.version, c.constraint, err)
+		}
+	}
+}
@@ -39,6 +39,18 @@ func (s *StepStartVM) Run(ctx context.Context, state multistep.StateBag) multist
 		return onError(err)
 	}
 
+	describeResponse, err := cmdClient.Describe(vmName)
+	if err != nil {
+		return onError(err)
+	}
+	if address := vncAddress(describeResponse.Display.FrameBuffer); address != "" {
+		message := fmt.Sprintf("VM %s can be watched over VNC: vnc://%s", vmName, address)
+		if describeResponse.Display.FrameBuffer.Password != "" {
+			message += " (password protected)"
+		}
+		ui.Say(message)
+	}
+
 	if config.BootDelay != "" {
 		d, err := time.ParseDuration(config.BootDelay)
 		if err != nil {
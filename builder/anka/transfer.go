@@ -0,0 +1,233 @@
+package anka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+const (
+	fileTransferAuto         = "auto"
+	fileTransferSharedVolume = "shared_volume"
+	fileTransferAnkaCP       = "anka_cp"
+	fileTransferTar          = "tar"
+)
+
+var fileTransferMethods = map[string]struct{}{
+	fileTransferAuto:         {},
+	fileTransferSharedVolume: {},
+	fileTransferAnkaCP:       {},
+	fileTransferTar:          {},
+}
+
+// fileTransfer is a way of moving files between the host and the guest.
+type fileTransfer interface {
+	Name() string
+	Upload(dst string, src io.Reader, fi *os.FileInfo) error
+	UploadDir(dst string, src string, exclude []string) error
+	Download(src string, dst io.Writer) error
+	DownloadDir(src string, dst string, exclude []string) error
+}
+
+// errNoFallback marks transfer errors that another method can't recover from.
+var errNoFallback = errors.New("not retrying with another transfer method")
+
+func (c *Communicator) newFileTransfer(method string) fileTransfer {
+	switch method {
+	case fileTransferSharedVolume:
+		return &sharedVolumeTransfer{comm: c}
+	case fileTransferAnkaCP:
+		return &ankaCPTransfer{comm: c}
+	case fileTransferTar:
+		return &tarTransfer{comm: c}
+	}
+	panic(fmt.Sprintf("unknown file transfer method %q", method))
+}
+
+// fileTransfers returns the transfer methods to try, in order. Detection is
+// only done on the first transfer of the build.
+func (c *Communicator) fileTransfers() []fileTransfer {
+	c.transfersOnce.Do(func() {
+		for _, method := range c.detectFileTransferMethods() {
+			c.transfers = append(c.transfers, c.newFileTransfer(method))
+		}
+	})
+
+	c.transfersLock.Lock()
+	defer c.transfersLock.Unlock()
+	return append([]fileTransfer{}, c.transfers...)
+}
+
+func (c *Communicator) detectFileTransferMethods() []string {
+	if c.Config.FileTransferMethod != fileTransferAuto {
+		return []string{c.Config.FileTransferMethod}
+	}
+
+	// Streaming a tar archive needs no copy on the host, so it comes first.
+	// The shared volume needs the anka FUSE driver in the guest.
+	if err := c.findFUSE(); err != nil {
+		log.Printf("Anka FUSE driver not found in the guest (%v), not using the shared volume", err)
+		return []string{fileTransferTar, fileTransferAnkaCP}
+	}
+	return []string{fileTransferTar, fileTransferSharedVolume, fileTransferAnkaCP}
+}
+
+func (c *Communicator) findFUSE() error {
+	notFound, _ := c.Client.Run(c.runParams(client.RunParams{
+		Script: "kextstat | grep \"com.veertu.filesystems.vtufs\" &>/dev/null",
+	}))
+	return notFound
+}
+
+// preferFileTransfer moves transfer to the front, so a method that worked
+// after another failed is tried first from then on.
+func (c *Communicator) preferFileTransfer(transfer fileTransfer) {
+	c.transfersLock.Lock()
+	defer c.transfersLock.Unlock()
+
+	transfers := []fileTransfer{transfer}
+	for _, t := range c.transfers {
+		if t != transfer {
+			transfers = append(transfers, t)
+		}
+	}
+	c.transfers = transfers
+}
+
+// withFileTransfer runs fn with each transfer method until one succeeds.
+func (c *Communicator) withFileTransfer(operation string, fn func(fileTransfer) error) error {
+	if err := c.reconnect(context.Background()); err != nil {
+		return err
+	}
+
+	var errs *packer.MultiError
+	for i, transfer := range c.fileTransfers() {
+		err := fn(transfer)
+		if err == nil {
+			if i > 0 {
+				log.Printf("%s succeeded with %s, preferring it from now on", operation, transfer.Name())
+				c.preferFileTransfer(transfer)
+			}
+			return nil
+		}
+		log.Printf("%s with %s failed: %v", operation, transfer.Name(), err)
+		errs = packer.MultiErrorAppend(errs, fmt.Errorf("%s: %w", transfer.Name(), err))
+		if errors.Is(err, errNoFallback) {
+			break
+		}
+	}
+	return errs
+}
+
+// guestDownloadSource splits the source of a directory download into the
+// guest directory to run in and the shell words naming what to copy from it.
+// As with the file provisioner, a trailing slash means the contents of the
+// directory and a glob in the last element selects the matching entries.
+func guestDownloadSource(src string) (dir string, words string) {
+	if strings.HasSuffix(src, "/") {
+		return src, "."
+	}
+	dir, base := path.Split(path.Clean(src))
+	if dir == "" {
+		dir = "."
+	}
+	if strings.ContainsAny(base, "*?[") {
+		return dir, quoteGlob(base)
+	}
+	return dir, client.ShellQuote(base)
+}
+
+// quoteGlob quotes pattern for the shell, leaving its glob characters to be
+// expanded.
+func quoteGlob(pattern string) string {
+	var quoted, literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			quoted.WriteString(client.ShellQuote(literal.String()))
+			literal.Reset()
+		}
+	}
+	for _, r := range pattern {
+		if strings.ContainsRune("*?[]", r) {
+			flush()
+			quoted.WriteRune(r)
+			continue
+		}
+		literal.WriteRune(r)
+	}
+	flush()
+	return quoted.String()
+}
+
+// retryableReader lets an upload be retried with another transfer method,
+// which is only possible if its source hasn't been read yet or can be
+// rewound.
+type retryableReader struct {
+	io.Reader
+	read   bool
+	offset int64
+}
+
+func newRetryableReader(r io.Reader) *retryableReader {
+	reader := &retryableReader{Reader: r}
+	if seeker, ok := r.(io.Seeker); ok {
+		if offset, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			reader.offset = offset
+		}
+	}
+	return reader
+}
+
+func (r *retryableReader) Read(p []byte) (int, error) {
+	r.read = true
+	return r.Reader.Read(p)
+}
+
+func (r *retryableReader) rewind() error {
+	if !r.read {
+		return nil
+	}
+	seeker, ok := r.Reader.(io.Seeker)
+	if !ok {
+		return fmt.Errorf("upload source was already consumed: %w", errNoFallback)
+	}
+	_, err := seeker.Seek(r.offset, io.SeekStart)
+	return err
+}
+
+// trackingWriter records whether a download has written anything, after which
+// it can't be retried with another transfer method.
+type trackingWriter struct {
+	io.Writer
+	written bool
+}
+
+func (w *trackingWriter) Write(p []byte) (int, error) {
+	w.written = w.written || len(p) > 0
+	return w.Writer.Write(p)
+}
+
+// writeFile writes the content read from r to target with mode.
+func writeFile(r io.Reader, target string, mode os.FileMode) error {
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, r); err != nil {
+		return err
+	}
+	if err := file.Chmod(mode); err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,154 @@
+package anka
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+// ankaCPTransfer copies files with anka cp.
+type ankaCPTransfer struct {
+	comm *Communicator
+}
+
+func (t *ankaCPTransfer) Name() string {
+	return fileTransferAnkaCP
+}
+
+func (t *ankaCPTransfer) Upload(dst string, src io.Reader, fi *os.FileInfo) error {
+	// anka cp only copies from paths, so the upload is stored first
+	tempfile, err := ioutil.TempFile(t.comm.HostDir, "upload")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempfile.Name())
+	defer tempfile.Close()
+
+	log.Printf("Copying from reader to %s", tempfile.Name())
+	w, err := io.Copy(tempfile, src)
+	if err != nil {
+		return err
+	}
+
+	if fi != nil {
+		tempfile.Chmod((*fi).Mode())
+	}
+	tempfile.Close()
+
+	if err := t.comm.Client.Copy(client.CopyParams{
+		Src: tempfile.Name(),
+		Dst: t.comm.VMName + ":" + dst,
+	}); err != nil {
+		return err
+	}
+	if err := t.chown(dst); err != nil {
+		return err
+	}
+
+	log.Printf("Copied %d bytes from %s to %s", w, tempfile.Name(), dst)
+	return nil
+}
+
+func (t *ankaCPTransfer) UploadDir(dst string, src string, exclude []string) error {
+	matcher := newExcludeMatcher(exclude)
+	if !matcher.empty() {
+		// anka cp can't leave files out, so what is left is staged first
+		td, err := ioutil.TempDir(t.comm.HostDir, "dirupload")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(td)
+
+		staged := filepath.Join(td, filepath.Base(src))
+		if err := copyTree(src, staged, matcher); err != nil {
+			return err
+		}
+		src = staged + src[len(strings.TrimRight(src, "/")):]
+	}
+
+	if err := t.comm.Client.Copy(client.CopyParams{
+		Src: src,
+		Dst: t.comm.VMName + ":" + dst,
+	}); err != nil {
+		return err
+	}
+
+	target := dst
+	if !strings.HasSuffix(src, "/") {
+		target = path.Join(dst, filepath.Base(src))
+	}
+	return t.chown(target)
+}
+
+// chown gives the uploaded target to run_as_user, as anka cp can't copy as
+// another user.
+func (t *ankaCPTransfer) chown(target string) error {
+	if t.comm.Config.RunAsUser == "" {
+		return nil
+	}
+	params := t.comm.runParams(client.RunParams{
+		Command: []string{"chown", "-R", t.comm.Config.RunAsUser, target},
+	})
+	// Only root can change the owner
+	params.User = ""
+	params.Sudo = true
+	err, _ := t.comm.Client.Run(params)
+	return err
+}
+
+func (t *ankaCPTransfer) Download(src string, dst io.Writer) error {
+	tempfile, err := ioutil.TempFile(t.comm.HostDir, "download")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempfile.Name())
+	defer tempfile.Close()
+
+	if err := t.comm.Client.Copy(client.CopyParams{
+		Src: t.comm.VMName + ":" + src,
+		Dst: tempfile.Name(),
+	}); err != nil {
+		return err
+	}
+
+	log.Printf("Copying from %s to writer", tempfile.Name())
+	w, err := io.Copy(dst, tempfile)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Copied %d bytes", w)
+	return nil
+}
+
+func (t *ankaCPTransfer) DownloadDir(src string, dst string, exclude []string) error {
+	matcher := newDownloadExcludeMatcher(src, exclude)
+	if matcher.empty() {
+		return t.comm.Client.Copy(client.CopyParams{
+			Src: t.comm.VMName + ":" + src,
+			Dst: dst,
+		})
+	}
+
+	// anka cp can't leave files out, so they are removed on the way from a
+	// temporary directory to dst
+	td, err := ioutil.TempDir(t.comm.HostDir, "dirdownload")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(td)
+
+	if err := t.comm.Client.Copy(client.CopyParams{
+		Src: t.comm.VMName + ":" + src,
+		Dst: td,
+	}); err != nil {
+		return err
+	}
+	return copyTree(td, dst, matcher)
+}
@@ -0,0 +1,135 @@
+package anka
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer/provisioner/file"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+// writeDownloadFixture creates the "guest" files to download.
+func writeDownloadFixture(t *testing.T, root string, modTime time.Time) {
+	files := map[string]os.FileMode{
+		"guest/cake":               0644,
+		"guest/dir/run.sh":         0755,
+		"guest/dir/sub/secret.txt": 0600,
+		"guest/logs/a.log":         0644,
+		"guest/logs/b.log":         0644,
+		"guest/logs/c.txt":         0644,
+	}
+	for name, mode := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(name), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("run.sh", filepath.Join(root, "guest", "dir", "link")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func provisionDownload(t *testing.T, comm packer.Communicator, source, destination string) {
+	provisioner := &file.Provisioner{}
+	err := provisioner.Prepare(map[string]interface{}{
+		"source":      source,
+		"destination": destination,
+		"direction":   "download",
+	})
+	if err != nil {
+		t.Fatalf("Error preparing download: %s", err)
+	}
+	if err := provisioner.Provision(context.Background(), packer.TestUi(t), comm, nil); err != nil {
+		t.Fatalf("Error downloading %s: %s", source, err)
+	}
+}
+
+func TestCommunicatorDownload(t *testing.T) {
+	withFakeAnka(t, nil)
+
+	for _, method := range []string{fileTransferSharedVolume, fileTransferTar} {
+		t.Run(method, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "anka-download")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+			for _, dir := range []string{"host", "volume"} {
+				if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			modTime := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+			writeDownloadFixture(t, root, modTime)
+
+			comm := &Communicator{
+				Config:  &Config{FileTransferMethod: method},
+				Client:  &client.Client{},
+				HostDir: filepath.Join(root, "volume"),
+				VMName:  "fake",
+			}
+			guest := filepath.Join(root, "guest")
+			host := filepath.Join(root, "host")
+
+			provisionDownload(t, comm, filepath.Join(guest, "cake"), filepath.Join(host, "cake"))
+			provisionDownload(t, comm, filepath.Join(guest, "dir")+"/", filepath.Join(host, "dir")+"/")
+			provisionDownload(t, comm, filepath.Join(guest, "logs", "[ab]*"), filepath.Join(host, "logs")+"/")
+
+			expected := map[string]os.FileMode{
+				"cake":               0644,
+				"dir/run.sh":         0755,
+				"dir/sub/secret.txt": 0600,
+				"logs/a.log":         0644,
+				"logs/b.log":         0644,
+			}
+			for name, mode := range expected {
+				path := filepath.Join(host, filepath.FromSlash(name))
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatalf("Missing download: %s", err)
+				}
+				if info.Mode().Perm() != mode {
+					t.Errorf("Unexpected mode %s for %s", info.Mode(), name)
+				}
+				// The file provisioner writes single files itself
+				if name != "cake" && !info.ModTime().Equal(modTime) {
+					t.Errorf("Unexpected modification time %s for %s", info.ModTime(), name)
+				}
+				content, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasSuffix(string(content), name) {
+					t.Errorf("Unexpected content %q for %s", content, name)
+				}
+			}
+
+			if link, err := os.Readlink(filepath.Join(host, "dir", "link")); err != nil || link != "run.sh" {
+				t.Errorf("Unexpected symlink %q: %v", link, err)
+			}
+			if _, err := os.Stat(filepath.Join(host, "logs", "c.txt")); !os.IsNotExist(err) {
+				t.Errorf("File not matching the glob was downloaded: %v", err)
+			}
+
+			// Nothing is left behind in the shared volume
+			if entries, _ := ioutil.ReadDir(comm.HostDir); len(entries) != 0 {
+				t.Errorf("Temporary files left in the shared volume: %d", len(entries))
+			}
+		})
+	}
+}
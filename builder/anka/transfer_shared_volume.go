@@ -0,0 +1,247 @@
+package anka
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+// sharedVolumeTransfer copies files through the build's temporary directory,
+// mounted in the guest with anka run -v. It needs the anka FUSE driver.
+type sharedVolumeTransfer struct {
+	comm *Communicator
+}
+
+func (t *sharedVolumeTransfer) Name() string {
+	return fileTransferSharedVolume
+}
+
+func (t *sharedVolumeTransfer) Upload(dst string, src io.Reader, fi *os.FileInfo) error {
+	// Create a temporary file to store the upload
+	tempfile, err := ioutil.TempFile(t.comm.HostDir, "upload")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempfile.Name())
+	defer tempfile.Close()
+
+	log.Printf("Copying from reader to %s", tempfile.Name())
+	w, err := io.Copy(tempfile, src)
+	if err != nil {
+		return err
+	}
+
+	if fi != nil {
+		tempfile.Chmod((*fi).Mode())
+	}
+	tempfile.Close()
+	if fi != nil {
+		os.Chtimes(tempfile.Name(), (*fi).ModTime(), (*fi).ModTime())
+	}
+
+	err, _ = t.comm.Client.Run(t.comm.runParams(client.RunParams{
+		Command: []string{"cp", "-p", path.Base(tempfile.Name()), dst},
+		Volume:  t.comm.HostDir,
+	}))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Copied %d bytes from %s to %s", w, tempfile.Name(), dst)
+	return nil
+}
+
+// UploadDir mounts src itself in the guest, so the tree isn't copied on the
+// host first.
+func (t *sharedVolumeTransfer) UploadDir(dst string, src string, exclude []string) error {
+	// Determine the destination directory
+	containerDst := dst
+	if src[len(src)-1] != '/' {
+		containerDst = filepath.Join(dst, filepath.Base(src))
+	}
+
+	log.Printf("from %#v to %#v", src, containerDst)
+
+	matcher := newExcludeMatcher(exclude)
+	if matcher.empty() {
+		// Make the directory, then copy into it
+		command := fmt.Sprintf("set -e; mkdir -p %[1]s; command cp -pPR ./ %[1]s", client.ShellQuote(containerDst))
+		err, _ := t.comm.Client.Run(t.comm.runParams(client.RunParams{
+			Command: []string{"bash", "-c", command},
+			Volume:  src,
+		}))
+		return err
+	}
+
+	// cp can't leave files out, so the guest archives the files listed on
+	// its input and extracts them at the destination.
+	var list bytes.Buffer
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relpath, err := filepath.Rel(src, path)
+		if err != nil || relpath == "." {
+			return err
+		}
+		if matcher.excluded(filepath.ToSlash(relpath), info.IsDir()) {
+			log.Printf("Excluding %s", path)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		fmt.Fprintln(&list, filepath.ToSlash(relpath))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	command := fmt.Sprintf("set -e; tar -c --no-recursion -f - -T - | (%s)", tarExtractCommand(containerDst))
+	err, _ = t.comm.Client.Run(t.comm.runParams(client.RunParams{
+		Script: command,
+		Stdin:  &list,
+		Volume: src,
+	}))
+	return err
+}
+
+func (t *sharedVolumeTransfer) Download(src string, dst io.Writer) error {
+	// Create a temporary file for the guest to copy the download into
+	tempfile, err := ioutil.TempFile(t.comm.HostDir, "download")
+	if err != nil {
+		return err
+	}
+	tempfile.Close()
+	defer os.Remove(tempfile.Name())
+
+	err, _ = t.comm.Client.Run(t.comm.runParams(client.RunParams{
+		Command: []string{"cp", src, "./" + path.Base(tempfile.Name())},
+		Volume:  t.comm.HostDir,
+	}))
+	if err != nil {
+		return err
+	}
+
+	// Only read the file once the guest is done with it
+	download, err := os.Open(tempfile.Name())
+	if err != nil {
+		return err
+	}
+	defer download.Close()
+
+	log.Printf("Copying from %s to writer", download.Name())
+	w, err := io.Copy(dst, download)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Copied %d bytes", w)
+	return nil
+}
+
+func (t *sharedVolumeTransfer) DownloadDir(src string, dst string, exclude []string) error {
+	// Create a temporary directory for the guest to copy the download into
+	td, err := ioutil.TempDir(t.comm.HostDir, "dirdownload")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(td)
+
+	dir, words := guestDownloadSource(src)
+	command := fmt.Sprintf("set -e; download=\"$(pwd)\"/%s; cd %s; command cp -pPR %s \"$download\"/",
+		filepath.Base(td), client.ShellQuote(dir), words)
+	err, _ = t.comm.Client.Run(t.comm.runParams(client.RunParams{
+		Command: []string{"bash", "-c", command},
+		Volume:  t.comm.HostDir,
+	}))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Copying from %s to %s", td, dst)
+	return copyTree(td, dst, newDownloadExcludeMatcher(src, exclude))
+}
+
+// copyTree copies the contents of src into dst, keeping modes, symlinks and
+// modification times, and leaving out what exclude matches.
+func copyTree(src string, dst string, exclude *excludeMatcher) error {
+	// Copying into a directory changes its modification time, so these are
+	// set once everything is copied.
+	dirTimes := map[string]time.Time{}
+
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relpath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if relpath != "." && exclude.excluded(filepath.ToSlash(relpath), info.IsDir()) {
+			log.Printf("Excluding %s", path)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, relpath)
+
+		switch {
+		case info.IsDir():
+			if err := os.MkdirAll(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			if err := os.Chmod(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			dirTimes[target] = info.ModTime()
+			return nil
+		case info.Mode()&os.ModeSymlink == os.ModeSymlink:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			os.Remove(target)
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			if err := copyFile(path, target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			return os.Chtimes(target, info.ModTime(), info.ModTime())
+		}
+
+		log.Printf("Skipping %s, unsupported file type %s", path, info.Mode().Type())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for dir, modTime := range dirTimes {
+		if err := os.Chtimes(dir, modTime, modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src string, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeFile(in, dst, mode)
+}
@@ -0,0 +1,275 @@
+package anka
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+// tarTransfer streams files over the stdin and stdout of anka run, packing
+// directories into a tar archive. It only needs sh, cat and tar in the guest.
+type tarTransfer struct {
+	comm *Communicator
+}
+
+func (t *tarTransfer) Name() string {
+	return fileTransferTar
+}
+
+// run runs command in the guest, returning its stderr in the error.
+func (t *tarTransfer) run(command string, stdin io.Reader, stdout io.Writer) error {
+	var stderr bytes.Buffer
+	err, _ := t.comm.Client.Run(t.comm.runParams(client.RunParams{
+		Script: command,
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: &stderr,
+	}))
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return fmt.Errorf("%w: %s", err, message)
+		}
+		return err
+	}
+	return nil
+}
+
+func (t *tarTransfer) Upload(dst string, src io.Reader, fi *os.FileInfo) error {
+	// Without the size there is no tar header to write, so the file is
+	// streamed as is.
+	if fi == nil || !(*fi).Mode().IsRegular() {
+		command := fmt.Sprintf("mkdir -p %s && cat > %s", client.ShellQuote(path.Dir(dst)), client.ShellQuote(dst))
+		return t.run(command, src, nil)
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeTarFile(writer, path.Base(dst), *fi, src))
+	}()
+	defer reader.Close()
+
+	return t.run(tarExtractCommand(path.Dir(dst)), reader, nil)
+}
+
+func (t *tarTransfer) UploadDir(dst string, src string, exclude []string) error {
+	// As with rsync, a trailing slash uploads the contents of src
+	if !strings.HasSuffix(src, "/") {
+		dst = path.Join(dst, filepath.Base(src))
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeTarArchive(writer, src, newExcludeMatcher(exclude)))
+	}()
+	defer reader.Close()
+
+	return t.run(tarExtractCommand(dst), reader, nil)
+}
+
+func (t *tarTransfer) Download(src string, dst io.Writer) error {
+	return t.run("cat "+client.ShellQuote(src), nil, dst)
+}
+
+func (t *tarTransfer) DownloadDir(src string, dst string, exclude []string) error {
+	dir, words := guestDownloadSource(src)
+	command := fmt.Sprintf("cd %s && tar -cf - %s", client.ShellQuote(dir), words)
+
+	reader, writer := io.Pipe()
+	extracted := make(chan error, 1)
+	go func() {
+		err := extractTarArchive(reader, dst, newDownloadExcludeMatcher(src, exclude))
+		if err == nil {
+			// tar pads the archive past its end marker
+			_, err = io.Copy(ioutil.Discard, reader)
+		}
+		reader.CloseWithError(err)
+		extracted <- err
+	}()
+
+	err := t.run(command, nil, writer)
+	writer.Close()
+	if extractErr := <-extracted; extractErr != nil {
+		return extractErr
+	}
+	return err
+}
+
+// tarExtractCommand extracts an archive from stdin into dir. Files belong to
+// the user running the command rather than their owner on the host.
+func tarExtractCommand(dir string) string {
+	return fmt.Sprintf("mkdir -p %[1]s && tar -x -p --no-same-owner -f - -C %[1]s", client.ShellQuote(dir))
+}
+
+// writeTarFile writes an archive holding a single file named name to w.
+func writeTarFile(w io.Writer, name string, info os.FileInfo, r io.Reader) error {
+	archive := tar.NewWriter(w)
+
+	header, err := tarHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := io.Copy(archive, r); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// tarHeader describes info without the host's owner.
+func tarHeader(info os.FileInfo, link string) (*tar.Header, error) {
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return nil, err
+	}
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+	return header, nil
+}
+
+// writeTarArchive writes the tree under root to w, with paths relative to
+// root, leaving out what exclude matches.
+func writeTarArchive(w io.Writer, root string, exclude *excludeMatcher) error {
+	archive := tar.NewWriter(w)
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relpath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if relpath == "." {
+			return nil
+		}
+		if exclude.excluded(filepath.ToSlash(relpath), info.IsDir()) {
+			log.Printf("Excluding %s", path)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink == os.ModeSymlink {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		header, err := tarHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relpath)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		log.Printf("Adding %s to the archive", path)
+		_, err = io.Copy(archive, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// extractTarArchive extracts the archive read from r under root, keeping the
+// modes, symlinks and modification times of its entries. Entries matched by
+// exclude are skipped.
+func extractTarArchive(r io.Reader, root string, exclude *excludeMatcher) error {
+	archive := tar.NewReader(r)
+
+	// Extracting into a directory changes its modification time, so these are
+	// set once everything is extracted.
+	dirTimes := map[string]time.Time{}
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if exclude.excluded(header.Name, header.Typeflag == tar.TypeDir) {
+			log.Printf("Excluding %s", header.Name)
+			continue
+		}
+
+		target := filepath.Join(root, filepath.FromSlash(header.Name))
+		if target != filepath.Clean(root) && !strings.HasPrefix(target, filepath.Clean(root)+string(os.PathSeparator)) {
+			return fmt.Errorf("archive entry %q is outside of %s", header.Name, root)
+		}
+		mode := os.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode); err != nil {
+				return err
+			}
+			if err := os.Chmod(target, mode); err != nil {
+				return err
+			}
+			dirTimes[target] = header.ModTime
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := writeFile(archive, target, mode); err != nil {
+				return err
+			}
+			if err := os.Chtimes(target, header.ModTime, header.ModTime); err != nil {
+				return err
+			}
+		default:
+			log.Printf("Skipping %s, unsupported archive entry type %c", header.Name, header.Typeflag)
+		}
+	}
+
+	for dir, modTime := range dirTimes {
+		if err := os.Chtimes(dir, modTime, modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package anka
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeTransfer struct {
+	name  string
+	err   error
+	calls int
+}
+
+func (t *fakeTransfer) Name() string { return t.name }
+
+func (t *fakeTransfer) Upload(dst string, src io.Reader, fi *os.FileInfo) error {
+	t.calls++
+	if _, err := io.Copy(ioutil.Discard, src); err != nil {
+		return err
+	}
+	return t.err
+}
+
+func (t *fakeTransfer) UploadDir(dst string, src string, exclude []string) error {
+	t.calls++
+	return t.err
+}
+
+func (t *fakeTransfer) Download(src string, dst io.Writer) error {
+	t.calls++
+	return t.err
+}
+
+func (t *fakeTransfer) DownloadDir(src string, dst string, exclude []string) error {
+	t.calls++
+	return t.err
+}
+
+func testCommunicator(transfers ...fileTransfer) *Communicator {
+	comm := &Communicator{}
+	comm.transfersOnce.Do(func() {})
+	comm.transfers = transfers
+	return comm
+}
+
+func TestFileTransferFallback(t *testing.T) {
+	broken := &fakeTransfer{name: "broken", err: errors.New("broken")}
+	working := &fakeTransfer{name: "working"}
+	comm := testCommunicator(broken, working)
+
+	if err := comm.Upload("/tmp/file", bytes.NewReader([]byte("data")), nil); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if broken.calls != 1 || working.calls != 1 {
+		t.Fatalf("Unexpected calls: broken %d, working %d", broken.calls, working.calls)
+	}
+
+	// The method that worked is tried first from now on
+	if err := comm.UploadDir("/tmp", "dir", nil); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if broken.calls != 1 || working.calls != 2 {
+		t.Fatalf("Unexpected calls: broken %d, working %d", broken.calls, working.calls)
+	}
+}
+
+func TestFileTransferNoFallbackAfterConsumingUpload(t *testing.T) {
+	broken := &fakeTransfer{name: "broken", err: errors.New("broken")}
+	working := &fakeTransfer{name: "working"}
+	comm := testCommunicator(broken, working)
+
+	// A pipe can't be rewound once read
+	reader, writer := io.Pipe()
+	go func() {
+		writer.Write([]byte("data"))
+		writer.Close()
+	}()
+
+	if err := comm.Upload("/tmp/file", reader, nil); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if working.calls != 0 {
+		t.Fatalf("Upload was retried with a consumed source")
+	}
+}
+
+func TestTarArchiveRoundTrip(t *testing.T) {
+	src, err := ioutil.TempDir("", "anka-tar-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir("", "anka-tar-dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "sub", "script.sh"), []byte("#!/bin/sh\n"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub/script.sh", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, path := range []string{filepath.Join(src, "sub", "script.sh"), filepath.Join(src, "sub")} {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var archive bytes.Buffer
+	if err := writeTarArchive(&archive, src, nil); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := extractTarArchive(&archive, dst, nil); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dst, "sub", "script.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0750 {
+		t.Fatalf("Unexpected mode %s", info.Mode())
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Fatalf("Unexpected modification time %s", info.ModTime())
+	}
+	info, err = os.Stat(filepath.Join(dst, "sub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Fatalf("Unexpected directory modification time %s", info.ModTime())
+	}
+	link, err := os.Readlink(filepath.Join(dst, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link != "sub/script.sh" {
+		t.Fatalf("Unexpected symlink target %q", link)
+	}
+}
+
+func TestWriteTarFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anka-tar-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "source")
+	if err := ioutil.WriteFile(path, []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	if err := writeTarFile(&archive, "renamed", info, bytes.NewReader([]byte("content"))); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := extractTarArchive(&archive, dir, nil); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "renamed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "content" {
+		t.Fatalf("Unexpected content %q", content)
+	}
+	renamed, err := os.Stat(filepath.Join(dir, "renamed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Mode().Perm() != 0600 || !renamed.ModTime().Equal(modTime) {
+		t.Fatalf("Unexpected mode %s or modification time %s", renamed.Mode(), renamed.ModTime())
+	}
+}
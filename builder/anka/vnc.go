@@ -0,0 +1,117 @@
+package anka
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/go-vnc"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+const vncDialTimeout = 10 * time.Second
+
+// vncConn is a VNC client connection. Closing it also stops relaying the
+// messages from the server.
+type vncConn struct {
+	*vnc.ClientConn
+
+	conn      net.Conn
+	closing   chan struct{}
+	relayDone chan struct{}
+	closeOnce sync.Once
+}
+
+// Close closes the connection and waits for the server messages to stop.
+func (c *vncConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closing)
+		err = c.conn.Close()
+		<-c.relayDone
+	})
+	return err
+}
+
+// relay passes the messages read by the client to messages until the
+// connection is closed, then discards them until the client stops reading.
+func (c *vncConn) relay(received <-chan vnc.ServerMessage, stopped <-chan struct{}, messages chan<- vnc.ServerMessage) {
+	defer close(c.relayDone)
+	for {
+		select {
+		case msg := <-received:
+			if messages == nil {
+				continue
+			}
+			select {
+			case messages <- msg:
+			case <-c.closing:
+			case <-stopped:
+				return
+			}
+		case <-stopped:
+			return
+		}
+	}
+}
+
+// stopNotifyConn tells when the client stops reading: go-vnc closes the
+// connection it was given once it is done with it.
+type stopNotifyConn struct {
+	net.Conn
+
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func (c *stopNotifyConn) Close() error {
+	c.stopOnce.Do(func() { close(c.stopped) })
+	return c.Conn.Close()
+}
+
+// dialVNC connects to the VNC server serving a VM's frame buffer. The
+// password from the VM description takes precedence over the configured one.
+// Messages from the server are sent to messages, if it isn't nil.
+func dialVNC(frameBuffer client.FrameBuffer, password string, messages chan<- vnc.ServerMessage) (*vncConn, error) {
+	address := vncAddress(frameBuffer)
+	if address == "" {
+		return nil, fmt.Errorf("VNC is not enabled on the VM, set vnc_port to enable it")
+	}
+	if frameBuffer.Password != "" {
+		password = frameBuffer.Password
+	}
+
+	conn, err := net.DialTimeout("tcp", address, vncDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to VNC at %s: %s", address, err)
+	}
+
+	auth := []vnc.ClientAuth{new(vnc.ClientAuthNone)}
+	if password != "" {
+		auth = []vnc.ClientAuth{&vnc.PasswordAuth{Password: password}}
+	}
+
+	// The client reads from its own connection and channel so that closing
+	// ours never leaves it blocked on a message nobody receives
+	notifyConn := &stopNotifyConn{Conn: conn, stopped: make(chan struct{})}
+	received := make(chan vnc.ServerMessage)
+	vncClient, err := vnc.Client(notifyConn, &vnc.ClientConfig{
+		Auth:            auth,
+		Exclusive:       false,
+		ServerMessageCh: received,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Error handshaking with VNC at %s: %s", address, err)
+	}
+
+	c := &vncConn{
+		ClientConn: vncClient,
+		conn:       conn,
+		closing:    make(chan struct{}),
+		relayDone:  make(chan struct{}),
+	}
+	go c.relay(received, notifyConn.stopped, messages)
+	return c, nil
+}
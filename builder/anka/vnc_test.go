@@ -0,0 +1,295 @@
+package anka
+
+import (
+	"context"
+	"encoding/binary"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/go-vnc"
+	"github.com/veertuinc/packer-builder-veertu-anka/client"
+)
+
+type rfbKeyEvent struct {
+	Key  uint32
+	Down bool
+}
+
+// rfbTestServer is a minimal RFB 3.8 server standing in for a VM's VNC
+// server. It serves a synthetic 32bpp frame and records key events.
+type rfbTestServer struct {
+	t        *testing.T
+	listener net.Listener
+	width    uint16
+	height   uint16
+	pixel    func(x, y int) (r, g, b uint8)
+	keys     chan rfbKeyEvent
+}
+
+func newRFBTestServer(t *testing.T, width, height uint16, pixel func(x, y int) (r, g, b uint8)) *rfbTestServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err)
+	}
+	s := &rfbTestServer{
+		t:        t,
+		listener: listener,
+		width:    width,
+		height:   height,
+		pixel:    pixel,
+		keys:     make(chan rfbKeyEvent, 1024),
+	}
+	go s.serve()
+	return s
+}
+
+func (s *rfbTestServer) Close() {
+	s.listener.Close()
+}
+
+func (s *rfbTestServer) frameBuffer() client.FrameBuffer {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	vncPort, _ := strconv.Atoi(port)
+	return client.FrameBuffer{VncIP: host, VncPort: vncPort, Width: int(s.width), Height: int(s.height)}
+}
+
+func (s *rfbTestServer) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	defer close(s.keys)
+
+	if err := s.handshake(conn); err != nil {
+		s.t.Logf("RFB handshake failed: %s", err)
+		return
+	}
+
+	for {
+		var messageType uint8
+		if err := binary.Read(conn, binary.BigEndian, &messageType); err != nil {
+			return
+		}
+		switch messageType {
+		case 0: // SetPixelFormat
+			if _, err := io.CopyN(io.Discard, conn, 19); err != nil {
+				return
+			}
+		case 2: // SetEncodings
+			var header struct {
+				Padding uint8
+				Count   uint16
+			}
+			if err := binary.Read(conn, binary.BigEndian, &header); err != nil {
+				return
+			}
+			if _, err := io.CopyN(io.Discard, conn, int64(header.Count)*4); err != nil {
+				return
+			}
+		case 3: // FramebufferUpdateRequest
+			if _, err := io.CopyN(io.Discard, conn, 9); err != nil {
+				return
+			}
+			if err := s.writeFrame(conn); err != nil {
+				return
+			}
+		case 4: // KeyEvent
+			var event struct {
+				Down    uint8
+				Padding [2]uint8
+				Key     uint32
+			}
+			if err := binary.Read(conn, binary.BigEndian, &event); err != nil {
+				return
+			}
+			s.keys <- rfbKeyEvent{Key: event.Key, Down: event.Down != 0}
+		case 5: // PointerEvent
+			if _, err := io.CopyN(io.Discard, conn, 5); err != nil {
+				return
+			}
+		case 6: // ClientCutText
+			var header struct {
+				Padding [3]uint8
+				Length  uint32
+			}
+			if err := binary.Read(conn, binary.BigEndian, &header); err != nil {
+				return
+			}
+			if _, err := io.CopyN(io.Discard, conn, int64(header.Length)); err != nil {
+				return
+			}
+		default:
+			s.t.Logf("Unexpected RFB client message type %d", messageType)
+			return
+		}
+	}
+}
+
+func (s *rfbTestServer) handshake(conn net.Conn) error {
+	if _, err := conn.Write([]byte("RFB 003.008\n")); err != nil {
+		return err
+	}
+	version := make([]byte, 12)
+	if _, err := io.ReadFull(conn, version); err != nil {
+		return err
+	}
+	// One security type: None
+	if _, err := conn.Write([]byte{1, 1}); err != nil {
+		return err
+	}
+	securityType := make([]byte, 1)
+	if _, err := io.ReadFull(conn, securityType); err != nil {
+		return err
+	}
+	if err := binary.Write(conn, binary.BigEndian, uint32(0)); err != nil {
+		return err
+	}
+	shared := make([]byte, 1)
+	if _, err := io.ReadFull(conn, shared); err != nil {
+		return err
+	}
+
+	name := "anka test"
+	serverInit := []interface{}{
+		s.width, s.height,
+		// 32bpp, depth 24, little endian, true color, 255 max, shifts 16/8/0
+		[16]uint8{32, 24, 0, 1, 0, 255, 0, 255, 0, 255, 16, 8, 0, 0, 0, 0},
+		uint32(len(name)), []byte(name),
+	}
+	for _, value := range serverInit {
+		if err := binary.Write(conn, binary.BigEndian, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *rfbTestServer) writeFrame(conn net.Conn) error {
+	header := []interface{}{
+		uint8(0), uint8(0), uint16(1), // FramebufferUpdate with one rectangle
+		uint16(0), uint16(0), s.width, s.height, int32(0), // raw encoding
+	}
+	for _, value := range header {
+		if err := binary.Write(conn, binary.BigEndian, value); err != nil {
+			return err
+		}
+	}
+	pixels := make([]byte, 0, int(s.width)*int(s.height)*4)
+	for y := 0; y < int(s.height); y++ {
+		for x := 0; x < int(s.width); x++ {
+			r, g, b := s.pixel(x, y)
+			pixels = append(pixels, b, g, r, 0)
+		}
+	}
+	_, err := conn.Write(pixels)
+	return err
+}
+
+func TestTypeBootCommand(t *testing.T) {
+	server := newRFBTestServer(t, 4, 4, func(x, y int) (uint8, uint8, uint8) { return 0, 0, 0 })
+	defer server.Close()
+
+	conn, err := dialVNC(server.frameBuffer(), "", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if err := typeBootCommand(context.Background(), conn, "aB<enter><leftCtrlOn>c<leftCtrlOff>", 1); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	conn.Close()
+
+	var events []rfbKeyEvent
+	for event := range server.keys {
+		events = append(events, event)
+	}
+
+	expected := []rfbKeyEvent{
+		{'a', true}, {'a', false},
+		{0xFFE1, true}, {'B', true}, {'B', false}, {0xFFE1, false},
+		{0xFF0D, true}, {0xFF0D, false},
+		{0xFFE3, true},
+		{'c', true}, {'c', false},
+		{0xFFE3, false},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("Unexpected key events:\n%v\nexpected:\n%v", events, expected)
+	}
+}
+
+func TestVNCConnClose(t *testing.T) {
+	server := newRFBTestServer(t, 4, 4, func(x, y int) (uint8, uint8, uint8) { return 0, 0, 0 })
+	defer server.Close()
+
+	// Nobody receives the messages, like after a screenshot was taken
+	messages := make(chan vnc.ServerMessage)
+	conn, err := dialVNC(server.frameBuffer(), "", messages)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := conn.FramebufferUpdateRequest(false, 0, 0, 4, 4); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		conn.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The server messages were still being read after the connection was closed")
+	}
+}
+
+func TestCaptureScreenshot(t *testing.T) {
+	pixel := func(x, y int) (uint8, uint8, uint8) {
+		return uint8(x * 10), uint8(y * 10), 200
+	}
+	server := newRFBTestServer(t, 16, 8, pixel)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "anka-screenshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "screenshot.png")
+
+	if err := captureScreenshot(server.frameBuffer(), "", path); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		t.Fatalf("Invalid PNG: %s", err)
+	}
+
+	if img.Bounds().Dx() != 16 || img.Bounds().Dy() != 8 {
+		t.Fatalf("Unexpected screenshot size: %v", img.Bounds())
+	}
+	for _, point := range [][2]int{{0, 0}, {3, 5}, {15, 7}} {
+		r, g, b, _ := img.At(point[0], point[1]).RGBA()
+		er, eg, eb := pixel(point[0], point[1])
+		if uint8(r>>8) != er || uint8(g>>8) != eg || uint8(b>>8) != eb {
+			t.Fatalf("Unexpected color at %v: %d,%d,%d", point, r>>8, g>>8, b>>8)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// auditBodyLimit caps how much of a command's machine readable body is kept
+// in each audit record.
+const auditBodyLimit = 4096
+
+// auditSecretFlags are the anka flags whose value is never written to the
+// audit log, like the VNC password of anka modify.
+var auditSecretFlags = map[string]struct{}{
+	"--password": {},
+}
+
+// auditRedacted replaces secret values in audit records.
+const auditRedacted = "<sensitive>"
+
+// AuditRecord describes a single invocation of the anka CLI.
+type AuditRecord struct {
+	Args      []string      `json:"args"`
+	StartTime time.Time     `json:"start_time"`
+	EndTime   time.Time     `json:"end_time"`
+	Duration  time.Duration `json:"duration_ns"`
+	ExitCode  int           `json:"exit_code"`
+	Status    string        `json:"status,omitempty"`
+	Code      int           `json:"code,omitempty"`
+	Message   string        `json:"message,omitempty"`
+	Body      string        `json:"body,omitempty"`
+	Truncated bool          `json:"body_truncated,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// AuditLog appends one JSON record per anka invocation to a file.
+type AuditLog struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewAuditLog opens (or creates) the audit log at path for appending.
+func NewAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open anka audit log %q: %w", path, err)
+	}
+	return &AuditLog{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Record writes a record to the audit log, with sensitive values hidden. A
+// nil AuditLog discards records.
+func (a *AuditLog) Record(record AuditRecord) error {
+	if a == nil {
+		return nil
+	}
+	args := redactArgs(record.Args)
+	for i, arg := range args {
+		args[i] = packer.LogSecretFilter.FilterString(arg)
+	}
+	record.Args = args
+	record.Message = packer.LogSecretFilter.FilterString(record.Message)
+	record.Body = packer.LogSecretFilter.FilterString(record.Body)
+	record.Error = packer.LogSecretFilter.FilterString(record.Error)
+	if len(record.Body) > auditBodyLimit {
+		record.Body = record.Body[:auditBodyLimit]
+		record.Truncated = true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.enc.Encode(record)
+}
+
+// Close closes the underlying file.
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+// redactArgs returns a copy of args with the values of auditSecretFlags
+// replaced, given either as the next argument or after an equal sign.
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	secretNext := false
+	for i, arg := range args {
+		if secretNext {
+			redacted[i] = auditRedacted
+			secretNext = false
+			continue
+		}
+		redacted[i] = arg
+		if _, ok := auditSecretFlags[arg]; ok {
+			secretNext = true
+			continue
+		}
+		if flag := strings.SplitN(arg, "=", 2); len(flag) == 2 {
+			if _, ok := auditSecretFlags[flag[0]]; ok {
+				redacted[i] = flag[0] + "=" + auditRedacted
+			}
+		}
+	}
+	return redacted
+}
+
+// newAuditRecord starts a record for an invocation of anka with args.
+func newAuditRecord(args []string) AuditRecord {
+	return AuditRecord{
+		Args:      append([]string{"anka"}, args...),
+		StartTime: time.Now(),
+	}
+}
+
+// finish fills in the timing and result fields of the record.
+func (r *AuditRecord) finish(exitCode int, err error, output *machineReadableOutput) {
+	r.EndTime = time.Now()
+	r.Duration = r.EndTime.Sub(r.StartTime)
+	r.ExitCode = exitCode
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if output != nil {
+		r.Status = output.Status
+		r.Code = output.Code
+		r.Message = output.Message
+		r.Body = string(output.Body)
+	}
+}
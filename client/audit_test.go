@@ -0,0 +1,105 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLog_Record(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anka-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	auditLog, err := NewAuditLog(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	record := newAuditRecord([]string{"--machine-readable", "show", "vm"})
+	record.finish(0, nil, &machineReadableOutput{
+		Status: statusOK,
+		Body:   []byte(`"` + strings.Repeat("a", auditBodyLimit*2) + `"`),
+	})
+	if err := auditLog.Record(record); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := auditLog.Record(newAuditRecord([]string{"version"})); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	auditLog.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("Invalid audit record %q: %s", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	if got := strings.Join(records[0].Args, " "); got != "anka --machine-readable show vm" {
+		t.Fatalf("Unexpected args: %q", got)
+	}
+	if records[0].Status != statusOK || !records[0].Truncated || len(records[0].Body) != auditBodyLimit {
+		t.Fatalf("Unexpected record: %+v", records[0])
+	}
+}
+
+func TestAuditLog_RecordRedactsSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anka-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	auditLog, err := NewAuditLog(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	args := []string{"--machine-readable", "modify", "vm", "set", "vnc", "--password", "hunter2", "--password=hunter3", "--port", "5901"}
+	if err := auditLog.Record(newAuditRecord(args)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	auditLog.Close()
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "hunter") {
+		t.Fatalf("Secret written to the audit log: %s", content)
+	}
+
+	var record AuditRecord
+	if err := json.Unmarshal(content, &record); err != nil {
+		t.Fatalf("Invalid audit record %q: %s", content, err)
+	}
+	expected := "anka --machine-readable modify vm set vnc --password <sensitive> --password=<sensitive> --port 5901"
+	if got := strings.Join(record.Args, " "); got != expected {
+		t.Fatalf("Unexpected args: %q", got)
+	}
+	if args[6] != "hunter2" {
+		t.Fatal("The recorded args were modified")
+	}
+}
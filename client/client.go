@@ -14,6 +14,8 @@ import (
 )
 
 type Client struct {
+	// AuditLog, when set, receives a record of every anka invocation.
+	AuditLog *AuditLog
 }
 
 type VersionResponse struct {
@@ -30,7 +32,12 @@ type VersionResponseBody struct {
 func (c *Client) Version() (VersionResponse, error) {
 	var response VersionResponse
 
-	out, err := exec.Command("anka", "--machine-readable", "version").Output()
+	args := []string{"--machine-readable", "version"}
+	record := newAuditRecord(args)
+	cmd := exec.Command("anka", args...)
+	out, err := cmd.Output()
+	record.finish(cmd.ProcessState.ExitCode(), err, &machineReadableOutput{Body: out})
+	c.audit(record)
 	if err != nil {
 		return response, err
 	}
@@ -45,7 +52,7 @@ type LicenseResponse struct {
 }
 
 func (c *Client) License() (LicenseResponse, error) {
-	output, err := runAnkaCommand("license", "show")
+	output, err := c.runAnkaCommand("license", "show")
 	if err != nil {
 		return LicenseResponse{}, err
 	}
@@ -64,7 +71,7 @@ type SuspendParams struct {
 }
 
 func (c *Client) Suspend(params SuspendParams) error {
-	_, err := runAnkaCommand("suspend", params.VMName)
+	_, err := c.runAnkaCommand("suspend", params.VMName)
 	return err
 }
 
@@ -79,13 +86,15 @@ func (c *Client) Start(params StartParams) error {
 		cmd = append(cmd, "--update-addons")
 	}
 	cmd = append(cmd, params.VMName)
-	_, err := runAnkaCommand(cmd...)
+	_, err := c.runAnkaCommand(cmd...)
 	return err
 }
 
 func (c *Client) Run(params RunParams) (error, int) {
-	runner := NewRunner(params)
-	runner.Start()
+	runner := c.NewRunner(params)
+	if err := runner.Start(); err != nil {
+		return err, 1
+	}
 
 	log.Printf("Waiting for command to run")
 	return runner.Wait()
@@ -118,7 +127,7 @@ func (c *Client) Create(params CreateParams, outputStreamer chan string) (Create
 		"--disk-size", params.DiskSize,
 		params.Name,
 	}
-	output, err := runAnkaCommandStreamer(outputStreamer, args...)
+	output, err := c.runAnkaCommandStreamer(outputStreamer, args...)
 	if err != nil {
 		return CreateResponse{}, err
 	}
@@ -155,45 +164,54 @@ type DescribeResponse struct {
 		PciSlot    int    `json:"pci_slot"`
 		File       string `json:"file"`
 	} `json:"hard_drives"`
-	NetworkCards []struct {
-		Index               int    `json:"index"`
-		Mode                string `json:"mode"`
-		MacAddress          string `json:"mac_address"`
-		PortForwardingRules []struct {
-			GuestPort int    `json:"guest_port"`
-			RuleName  string `json:"rule_name"`
-			Protocol  string `json:"protocol"`
-			HostIP    string `json:"host_ip"`
-			HostPort  int    `json:"host_port"`
-		} `json:"port_forwarding_rules"`
-		PciSlot int    `json:"pci_slot"`
-		Type    string `json:"type"`
-	} `json:"network_cards"`
+	NetworkCards []NetworkCard `json:"network_cards"`
 	Smbios struct {
 		Type string `json:"type"`
 	} `json:"smbios"`
 	Smc struct {
 		Type string `json:"type"`
 	} `json:"smc"`
-	Nvram    bool `json:"nvram"`
+	CustomVariables map[string]string `json:"custom_variables"`
+	Nvram           bool              `json:"nvram"`
 	Firmware struct {
 		Type string `json:"type"`
 	} `json:"firmware"`
-	Display struct {
-		Headless    int `json:"headless"`
-		FrameBuffer struct {
-			PciSlot  int    `json:"pci_slot"`
-			VncPort  int    `json:"vnc_port"`
-			Height   int    `json:"height"`
-			Width    int    `json:"width"`
-			VncIP    string `json:"vnc_ip"`
-			Password string `json:"password"`
-		} `json:"frame_buffer"`
-	} `json:"display"`
+	Display Display `json:"display"`
+}
+
+type Display struct {
+	Headless    int         `json:"headless"`
+	FrameBuffer FrameBuffer `json:"frame_buffer"`
+}
+
+type FrameBuffer struct {
+	PciSlot  int    `json:"pci_slot"`
+	VncPort  int    `json:"vnc_port"`
+	Height   int    `json:"height"`
+	Width    int    `json:"width"`
+	VncIP    string `json:"vnc_ip"`
+	Password string `json:"password"`
+}
+
+type NetworkCard struct {
+	Index               int                  `json:"index"`
+	Mode                string               `json:"mode"`
+	MacAddress          string               `json:"mac_address"`
+	PortForwardingRules []PortForwardingRule `json:"port_forwarding_rules"`
+	PciSlot             int                  `json:"pci_slot"`
+	Type                string               `json:"type"`
+}
+
+type PortForwardingRule struct {
+	GuestPort int    `json:"guest_port"`
+	RuleName  string `json:"rule_name"`
+	Protocol  string `json:"protocol"`
+	HostIP    string `json:"host_ip"`
+	HostPort  int    `json:"host_port"`
 }
 
 func (c *Client) Describe(vmName string) (DescribeResponse, error) {
-	output, err := runAnkaCommand("describe", vmName)
+	output, err := c.runAnkaCommand("describe", vmName)
 	if err != nil {
 		return DescribeResponse{}, err
 	}
@@ -207,6 +225,16 @@ func (c *Client) Describe(vmName string) (DescribeResponse, error) {
 	return response, nil
 }
 
+// RawBody runs an anka command and returns its machine readable body as anka
+// printed it, with the fields the response types don't model.
+func (c *Client) RawBody(args ...string) (json.RawMessage, error) {
+	output, err := c.runAnkaCommand(args...)
+	if err != nil {
+		return nil, err
+	}
+	return output.Body, nil
+}
+
 type ShowResponse struct {
 	UUID      string `json:"uuid"`
 	Name      string `json:"name"`
@@ -226,7 +254,7 @@ func (sr ShowResponse) IsStopped() bool {
 }
 
 func (c *Client) Show(vmName string) (ShowResponse, error) {
-	output, err := runAnkaCommand("show", vmName)
+	output, err := c.runAnkaCommand("show", vmName)
 	if err != nil {
 		merr, ok := err.(machineReadableError)
 		if ok {
@@ -252,7 +280,7 @@ type CopyParams struct {
 }
 
 func (c *Client) Copy(params CopyParams) error {
-	_, err := runAnkaCommand("cp", "-af", params.Src, params.Dst)
+	_, err := c.runAnkaCommand("cp", "-af", params.Src, params.Dst)
 	return err
 }
 
@@ -262,7 +290,7 @@ type CloneParams struct {
 }
 
 func (c *Client) Clone(params CloneParams) error {
-	_, err := runAnkaCommand("clone", params.SourceUUID, params.VMName)
+	_, err := c.runAnkaCommand("clone", params.SourceUUID, params.VMName)
 	if err != nil {
 		merr, ok := err.(machineReadableError)
 		if ok {
@@ -291,7 +319,7 @@ func (c *Client) Stop(params StopParams) error {
 	}
 
 	args = append(args, params.VMName)
-	_, err := runAnkaCommand(args...)
+	_, err := c.runAnkaCommand(args...)
 	return err
 }
 
@@ -306,7 +334,7 @@ func (c *Client) Delete(params DeleteParams) error {
 	}
 
 	args = append(args, params.VMName)
-	_, err := runAnkaCommand(args...)
+	_, err := c.runAnkaCommand(args...)
 	return err
 }
 
@@ -323,10 +351,56 @@ func (c *Client) Exists(vmName string) (bool, error) {
 	return false, err
 }
 
+type ListResponse struct {
+	UUID   string `json:"uuid"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+func (c *Client) List() ([]ListResponse, error) {
+	output, err := c.runAnkaCommand("list")
+	if err != nil {
+		return nil, err
+	}
+
+	var response []ListResponse
+	err = json.Unmarshal(output.Body, &response)
+	return response, err
+}
+
+type RegistryListResponse struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Latest string `json:"latest"`
+}
+
+// RegistryList lists the templates in the default registry.
+func (c *Client) RegistryList() ([]RegistryListResponse, error) {
+	output, err := c.runAnkaCommand("registry", "list")
+	if err != nil {
+		return nil, err
+	}
+
+	var response []RegistryListResponse
+	err = json.Unmarshal(output.Body, &response)
+	return response, err
+}
+
+// RegistryDelete deletes a template, or only one of its tags, from the
+// default registry.
+func (c *Client) RegistryDelete(id string, tag string) error {
+	args := []string{"registry", "delete", "--id", id}
+	if tag != "" {
+		args = append(args, "--tag", tag)
+	}
+	_, err := c.runAnkaCommand(args...)
+	return err
+}
+
 func (c *Client) Modify(vmName string, command string, property string, flags ...string) error {
 	ankaCommand := []string{"modify", vmName, command, property}
 	ankaCommand = append(ankaCommand, flags...)
-	output, err := runAnkaCommand(ankaCommand...)
+	output, err := c.runAnkaCommand(ankaCommand...)
 	if err != nil {
 		return err
 	}
@@ -337,24 +411,40 @@ func (c *Client) Modify(vmName string, command string, property string, flags ..
 	return nil
 }
 
-func runAnkaCommand(args ...string) (machineReadableOutput, error) {
-	return runAnkaCommandStreamer(nil, args...)
+func (c *Client) runAnkaCommand(args ...string) (machineReadableOutput, error) {
+	return c.runAnkaCommandStreamer(nil, args...)
 }
 
-func runAnkaCommandStreamer(outputStreamer chan string, args ...string) (machineReadableOutput, error) {
+func (c *Client) runAnkaCommandStreamer(outputStreamer chan string, args ...string) (machineReadableOutput, error) {
 
 	if outputStreamer != nil {
 		args = append([]string{"--debug"}, args...)
 	}
 
 	cmdArgs := append([]string{"--machine-readable"}, args...)
+	record := newAuditRecord(cmdArgs)
+	parsed, exitCode, err := execMachineReadable(outputStreamer, cmdArgs)
+	record.finish(exitCode, err, parsed)
+	c.audit(record)
+	if err != nil {
+		return machineReadableOutput{}, err
+	}
+
+	if err = parsed.GetError(); err != nil {
+		return machineReadableOutput{}, err
+	}
+
+	return *parsed, nil
+}
+
+func execMachineReadable(outputStreamer chan string, cmdArgs []string) (*machineReadableOutput, int, error) {
 	log.Printf("Executing anka %s", strings.Join(cmdArgs, " "))
 	cmd := exec.Command("anka", cmdArgs...)
 
 	outPipe, err := cmd.StdoutPipe()
 	if err != nil {
 		log.Println("Err on stdoutpipe")
-		return machineReadableOutput{}, err
+		return nil, -1, err
 	}
 
 	if outputStreamer == nil {
@@ -363,7 +453,7 @@ func runAnkaCommandStreamer(outputStreamer chan string, args ...string) (machine
 
 	if err = cmd.Start(); err != nil {
 		log.Printf("Failed with an error of %v", err)
-		return machineReadableOutput{}, err
+		return nil, -1, err
 	}
 	outScanner := bufio.NewScanner(outPipe)
 	outScanner.Split(customSplit)
@@ -378,11 +468,13 @@ func runAnkaCommandStreamer(outputStreamer chan string, args ...string) (machine
 	}
 
 	scannerErr := outScanner.Err() // Expecting error on final output
+	waitErr := cmd.Wait()
+	exitCode := cmd.ProcessState.ExitCode()
 	if scannerErr == nil {
-		return machineReadableOutput{}, errors.New("missing machine readable output")
+		return nil, exitCode, errors.New("missing machine readable output")
 	}
 	if _, ok := scannerErr.(customErr); !ok {
-		return machineReadableOutput{}, err
+		return nil, exitCode, scannerErr
 	}
 
 	finalOutput := scannerErr.Error()
@@ -390,15 +482,21 @@ func runAnkaCommandStreamer(outputStreamer chan string, args ...string) (machine
 
 	parsed, err := parseOutput([]byte(finalOutput))
 	if err != nil {
-		return machineReadableOutput{}, err
+		if waitErr != nil {
+			err = fmt.Errorf("%v (%v)", err, waitErr)
+		}
+		return nil, exitCode, err
 	}
-	cmd.Wait()
 
-	if err = parsed.GetError(); err != nil {
-		return machineReadableOutput{}, err
-	}
+	return &parsed, exitCode, nil
+}
 
-	return parsed, nil
+// audit writes record to the audit log, if one is configured. Failing to
+// audit a command never fails the command itself.
+func (c *Client) audit(record AuditRecord) {
+	if err := c.AuditLog.Record(record); err != nil {
+		log.Printf("Failed writing anka audit record: %v", err)
+	}
 }
 
 const (
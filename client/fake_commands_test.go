@@ -0,0 +1,58 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// fakeSudo reports the user and password it was given, then runs the command.
+const fakeSudo = `#!/bin/sh
+user=root
+password=
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-H|-n) shift ;;
+	-u) user="$2"; shift 2 ;;
+	-A) password=$("$SUDO_ASKPASS"); shift ;;
+	*) break ;;
+	esac
+done
+echo "user=$user password=$password"
+exec "$@"
+`
+
+// fakeAnkaRun stands in for anka run -n, running the command on the host.
+const fakeAnkaRun = `#!/bin/sh
+[ "$1" = "run" ] && [ "$2" = "-n" ] || exit 1
+shift 3
+exec "$@"
+`
+
+// withFakeCommands puts scripts, keyed by command name, first on PATH for the
+// duration of the test and returns their directory.
+func withFakeCommands(t *testing.T, scripts map[string]string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("The fake commands are shell scripts")
+	}
+
+	dir, err := ioutil.TempDir("", "fake-commands")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, script := range scripts {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	t.Cleanup(func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	})
+	return dir
+}
@@ -2,10 +2,14 @@ package client
 
 import (
 	// "syscall"
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
 	"time"
 
@@ -13,19 +17,47 @@ import (
 )
 
 type RunParams struct {
-	VMName         string
-	Volume         string
-	Command        []string
+	VMName string
+	Volume string
+	// Command is run with its arguments quoted, unless Script is set
+	Command []string
+	// Script is shell script text run as is instead of Command
+	Script         string
 	Stdin          io.Reader
 	Stdout, Stderr io.Writer
 	Debug          bool
-	User           string
+	// User runs Command as another user with sudo
+	User string
+	// Sudo runs Command with sudo, as root unless User is set
+	Sudo bool
+	// SudoPassword is given to sudo through an askpass helper, otherwise
+	// sudo must not need a password
+	SudoPassword string
+	// sudoPasswordFile is the guest file holding SudoPassword
+	sudoPasswordFile string
+	// Env is exported in the shell running Command
+	Env map[string]string
+	// Shell runs Command, sh unless set
+	Shell string
+	// LoginShell runs Shell as a login shell, so it reads the user's profile
+	LoginShell bool
 }
 
 type Runner struct {
-	params  RunParams
-	cmd     *exec.Cmd
+	params RunParams
+	cmd    *exec.Cmd
+	// args are the arguments to anka before the command
+	args    []string
 	started time.Time
+	audit   *AuditLog
+}
+
+// NewRunner creates a Runner whose invocation is recorded in the client's
+// audit log.
+func (c *Client) NewRunner(params RunParams) *Runner {
+	runner := NewRunner(params)
+	runner.audit = c.AuditLog
+	return runner
 }
 
 func NewRunner(params RunParams) *Runner {
@@ -52,9 +84,15 @@ func NewRunner(params RunParams) *Runner {
 	}
 
 	args = append(args, params.VMName)
-	args = append(args, "sh")
 
+	// The command is passed as an argument so that stdin is left for the
+	// command's input. With a sudo password it is only known once the
+	// password is in the guest.
 	cmd := exec.Command("anka", args...)
+	if params.SudoPassword == "" {
+		cmd.Args = append(cmd.Args, params.invocation()...)
+	}
+	cmd.Stdin = params.Stdin
 	cmd.Stdout = params.Stdout
 	cmd.Stderr = params.Stderr
 
@@ -66,33 +104,171 @@ func NewRunner(params RunParams) *Runner {
 	return &Runner{
 		params: params,
 		cmd:    cmd,
+		args:   args,
 	}
 }
 
 func (r *Runner) Start() error {
-	log.Printf("Starting command: %s", strings.Join(r.cmd.Args, " "))
+	if r.params.SudoPassword != "" {
+		file, err := r.stashSudoPassword()
+		if err != nil {
+			return err
+		}
+		r.params.sudoPasswordFile = file
+		r.cmd.Args = append(r.cmd.Args, r.params.invocation()...)
+	}
+
+	log.Printf("Starting command: %s", strings.Join(r.redactedArgs(), " "))
 	r.started = time.Now()
-	stdin, err := r.cmd.StdinPipe()
-	if err != nil {
+	if err := r.cmd.Start(); err != nil {
+		r.removeSudoPassword()
 		return err
 	}
-	err = r.cmd.Start()
-	if err != nil {
-		return err
+	return nil
+}
+
+// stashSudoPassword writes the sudo password to a guest file only the guest
+// user can read. The password goes through stdin so that it never shows in a
+// command line, on the host or in the guest.
+func (r *Runner) stashSudoPassword() (string, error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command("anka", "run", "-n", r.params.VMName,
+		"sh", "-c", `umask 077 && file=$(mktemp) && cat > "$file" && echo "$file"`)
+	cmd.Stdin = strings.NewReader(r.params.SudoPassword)
+	cmd.Stdout = &stdout
+	cmd.Stderr = r.params.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to pass the sudo password to the guest: %w", err)
+	}
+
+	file := strings.TrimSpace(stdout.String())
+	if file == "" {
+		return "", errors.New("failed to pass the sudo password to the guest: no file was created")
+	}
+	return file, nil
+}
+
+// removeSudoPassword removes the guest file stashed by Start, in case the
+// command failed before its script could. It is best effort, since the guest
+// may be going down.
+func (r *Runner) removeSudoPassword() {
+	if r.params.sudoPasswordFile == "" {
+		return
+	}
+	cmd := exec.Command("anka", "run", "-n", r.params.VMName, "rm", "-f", r.params.sudoPasswordFile)
+	if err := cmd.Run(); err != nil {
+		log.Printf("Failed to remove the sudo password file %s from the guest: %v", r.params.sudoPasswordFile, err)
+	}
+}
+
+// invocation returns the command line running the command with its
+// environment in the shell, through sudo when needed.
+func (p RunParams) invocation() []string {
+	shell := []string{p.Shell}
+	if p.Shell == "" {
+		shell[0] = "sh"
+	}
+	if p.LoginShell {
+		shell = append(shell, "-l")
+	}
+	shell = append(shell, "-c", p.script())
+
+	if p.User == "" && !p.Sudo {
+		return shell
+	}
+	return []string{"sh", "-c", p.sudoScript(shell)}
+}
+
+// script returns the shell script running the command with its environment.
+func (p RunParams) script() string {
+	keys := make([]string, 0, len(p.Env))
+	for key := range p.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var script strings.Builder
+	for _, key := range keys {
+		script.WriteString("export " + key + "=" + ShellQuote(p.Env[key]) + "\n")
+	}
+	if p.Script != "" {
+		script.WriteString(p.Script)
+	} else {
+		script.WriteString(ShellQuote(p.Command...))
 	}
-	cmdString := strings.Join(r.params.Command, " ")
-	log.Print("Executing on sh: ", cmdString)
-	stdin.Write([]byte(cmdString))
-	stdin.Close()
-	return err
+	return script.String()
+}
+
+// sudoScript returns a POSIX shell script running shell with sudo. The
+// environment is exported by shell since sudo resets it.
+func (p RunParams) sudoScript(shell []string) string {
+	sudo := []string{"sudo", "-H"}
+	if p.User != "" {
+		sudo = append(sudo, "-u", p.User)
+	}
+	if p.SudoPassword == "" {
+		sudo = append(append(sudo, "-n"), shell...)
+		return ShellQuote(sudo...)
+	}
+
+	// The askpass helper reads the password from the file stashed by Start,
+	// which is removed along with the helper
+	passwordFile := ShellQuote(p.sudoPasswordFile)
+	askpass := ShellQuote("#!/bin/sh", "exec cat "+passwordFile)
+	sudo = append(append(sudo, "-A"), shell...)
+	return strings.Join([]string{
+		`askpass=$(mktemp) && printf '%s\n' ` + askpass + ` > "$askpass" && chmod 700 "$askpass" || { rm -f ` + passwordFile + `; exit 1; }`,
+		`SUDO_ASKPASS="$askpass" ` + ShellQuote(sudo...),
+		"status=$?",
+		`rm -f "$askpass" ` + passwordFile,
+		"exit $status",
+	}, "\n")
 }
 
 func (r *Runner) Wait() (error, int) {
 	err := r.cmd.Wait()
 	log.Printf("Command finished in %s with %v", time.Now().Sub(r.started), err)
+	if err != nil {
+		r.removeSudoPassword()
+	}
+	r.record(err)
 	return err, getExitCode(err)
 }
 
+// Kill stops a started command.
+func (r *Runner) Kill() error {
+	if r.cmd.Process == nil {
+		return nil
+	}
+	return r.cmd.Process.Kill()
+}
+
+func (r *Runner) record(err error) {
+	if r.audit == nil {
+		return
+	}
+	record := AuditRecord{
+		Args:      r.redactedArgs(),
+		StartTime: r.started,
+	}
+	record.finish(r.cmd.ProcessState.ExitCode(), err, nil)
+	if auditErr := r.audit.Record(record); auditErr != nil {
+		log.Printf("Failed writing anka audit record: %v", auditErr)
+	}
+}
+
+// redactedArgs returns the anka command line with the values of Env hidden,
+// since the guest environment can hold secrets.
+func (r *Runner) redactedArgs() []string {
+	params := r.params
+	params.Env = make(map[string]string, len(r.params.Env))
+	for key := range r.params.Env {
+		params.Env[key] = auditRedacted
+	}
+	args := append([]string{"anka"}, r.args...)
+	return append(args, params.invocation()...)
+}
+
 // GetExitCode extracts an exit code from an error where the platform supports it,
 // otherwise returns 0 for no error and 1 for an error
 func getExitCode(err error) int {
@@ -0,0 +1,223 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRunParamsScript(t *testing.T) {
+	params := RunParams{
+		Script: `echo "$GREETING" "$QUOTED"`,
+		Env: map[string]string{
+			"QUOTED":   `it's "quoted" $HOME`,
+			"GREETING": "hello world",
+		},
+	}
+
+	expected := "export GREETING='hello world'\n" +
+		`export QUOTED='it'"'"'s "quoted" $HOME'` + "\n" +
+		`echo "$GREETING" "$QUOTED"`
+	if script := params.script(); script != expected {
+		t.Fatalf("Unexpected script:\n%s\nexpected:\n%s", script, expected)
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	output, err := exec.Command("sh", "-c", params.script()).Output()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(output) != "hello world it's \"quoted\" $HOME\n" {
+		t.Fatalf("Unexpected output %q", output)
+	}
+}
+
+func TestRunParamsScriptQuotesCommand(t *testing.T) {
+	params := RunParams{Command: []string{"echo", "it's", "$HOME", "a b"}}
+
+	expected := `echo 'it'"'"'s' '$HOME' 'a b'`
+	if script := params.script(); script != expected {
+		t.Fatalf("Unexpected script %q, expected %q", script, expected)
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	output, err := exec.Command("sh", "-c", params.script()).Output()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(output) != "it's $HOME a b\n" {
+		t.Fatalf("Unexpected output %q", output)
+	}
+}
+
+func TestRunnerArgs(t *testing.T) {
+	runner := NewRunner(RunParams{VMName: "vm", Command: []string{"cat"}, Volume: "/tmp"})
+
+	expected := []string{"anka", "run", "-v", "/tmp", "vm", "sh", "-c", "cat"}
+	if !reflect.DeepEqual(runner.cmd.Args, expected) {
+		t.Fatalf("Unexpected args %q", runner.cmd.Args)
+	}
+
+	runner = NewRunner(RunParams{VMName: "vm", Command: []string{"brew", "update"}, Shell: "zsh", LoginShell: true})
+
+	expected = []string{"anka", "run", "-n", "vm", "zsh", "-l", "-c", "brew update"}
+	if !reflect.DeepEqual(runner.cmd.Args, expected) {
+		t.Fatalf("Unexpected args %q", runner.cmd.Args)
+	}
+
+	runner = NewRunner(RunParams{VMName: "vm", Command: []string{"whoami"}, Shell: "/bin/bash", User: "builder"})
+
+	expected = []string{"anka", "run", "-n", "vm", "sh", "-c", "sudo -H -u builder -n /bin/bash -c whoami"}
+	if !reflect.DeepEqual(runner.cmd.Args, expected) {
+		t.Fatalf("Unexpected args %q", runner.cmd.Args)
+	}
+}
+
+func TestRunParamsScriptSudo(t *testing.T) {
+	dir := withFakeCommands(t, map[string]string{"sudo": fakeSudo})
+
+	cases := []struct {
+		params   RunParams
+		expected string
+	}{
+		{RunParams{Sudo: true}, "user=root password=\n"},
+		{RunParams{User: "builder"}, "user=builder password=\n"},
+		{RunParams{User: "builder", Sudo: true, SudoPassword: `pa$$ 'word'`}, "user=builder password=pa$$ 'word'\n"},
+	}
+	for _, c := range cases {
+		c.params.Script = "echo $GREETING"
+		c.params.Env = map[string]string{"GREETING": "hello"}
+		if c.params.SudoPassword != "" {
+			c.params.sudoPasswordFile = filepath.Join(dir, "password")
+			if err := ioutil.WriteFile(c.params.sudoPasswordFile, []byte(c.params.SudoPassword), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		invocation := c.params.invocation()
+		output, err := exec.Command(invocation[0], invocation[1:]...).Output()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if string(output) != c.expected+"hello\n" {
+			t.Errorf("Unexpected output %q for %+v", output, c.params)
+		}
+	}
+}
+
+func TestRunnerSudoPassword(t *testing.T) {
+	withFakeCommands(t, map[string]string{"anka": fakeAnkaRun, "sudo": fakeSudo})
+
+	password := `pa$$ 'word'`
+	var stdout bytes.Buffer
+	runner := NewRunner(RunParams{
+		VMName:       "vm",
+		Command:      []string{"echo", "hello"},
+		Sudo:         true,
+		SudoPassword: password,
+		Stdout:       &stdout,
+	})
+	if err := runner.Start(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err, _ := runner.Wait(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if stdout.String() != "user=root password="+password+"\nhello\n" {
+		t.Fatalf("Unexpected output %q", stdout.String())
+	}
+	for _, arg := range runner.cmd.Args {
+		if strings.Contains(arg, "word") {
+			t.Fatalf("The sudo password is in the command line %q", runner.cmd.Args)
+		}
+	}
+	if _, err := os.Stat(runner.params.sudoPasswordFile); !os.IsNotExist(err) {
+		t.Fatalf("The sudo password file %s wasn't removed: %v", runner.params.sudoPasswordFile, err)
+	}
+}
+
+func TestRunnerAuditRedactsEnv(t *testing.T) {
+	dir := withFakeCommands(t, map[string]string{"anka": fakeAnkaRun})
+
+	path := filepath.Join(dir, "audit.log")
+	auditLog, err := NewAuditLog(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var stdout bytes.Buffer
+	c := &Client{AuditLog: auditLog}
+	runner := c.NewRunner(RunParams{
+		VMName: "vm",
+		Script: "echo $TOKEN",
+		Env:    map[string]string{"TOKEN": "hunter2"},
+		Stdout: &stdout,
+	})
+	if err := runner.Start(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err, _ := runner.Wait(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	auditLog.Close()
+
+	if stdout.String() != "hunter2\n" {
+		t.Fatalf("Unexpected output %q", stdout.String())
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record AuditRecord
+	if err := json.Unmarshal(content, &record); err != nil {
+		t.Fatalf("Invalid audit record %q: %s", content, err)
+	}
+	expected := []string{"anka", "run", "-n", "vm", "sh", "-c", "export TOKEN='<sensitive>'\necho $TOKEN"}
+	if !reflect.DeepEqual(record.Args, expected) {
+		t.Fatalf("Unexpected args %q", record.Args)
+	}
+}
+
+func TestRunnerSudoPasswordRemovedOnDisconnect(t *testing.T) {
+	// Stashing the password works, then the VM disconnects before the
+	// command's script runs
+	withFakeCommands(t, map[string]string{"anka": `#!/bin/sh
+[ "$1" = "run" ] && [ "$2" = "-n" ] || exit 1
+shift 3
+case "$*" in
+*SUDO_ASKPASS*) exit 125 ;;
+esac
+exec "$@"
+`})
+
+	runner := NewRunner(RunParams{
+		VMName:       "vm",
+		Command:      []string{"true"},
+		Sudo:         true,
+		SudoPassword: "secret",
+	})
+	if err := runner.Start(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, err := os.Stat(runner.params.sudoPasswordFile); err != nil {
+		t.Fatalf("The sudo password wasn't stashed: %v", err)
+	}
+	if err, _ := runner.Wait(); err == nil {
+		t.Fatal("Expected the disconnect to fail the command")
+	}
+	if _, err := os.Stat(runner.params.sudoPasswordFile); !os.IsNotExist(err) {
+		t.Fatalf("The sudo password file %s wasn't removed: %v", runner.params.sudoPasswordFile, err)
+	}
+}
@@ -4,9 +4,11 @@ go 1.16
 
 require (
 	github.com/groob/plist v0.0.0-20200425180238-0f631f258c01
-	github.com/hashicorp/hcl/v2 v2.9.0
+	github.com/hashicorp/go-version v1.3.0
+	github.com/hashicorp/hcl/v2 v2.10.1
 	github.com/hashicorp/packer v1.7.0
-	github.com/hashicorp/packer-plugin-sdk v0.1.0
+	github.com/hashicorp/packer-plugin-sdk v0.2.7
+	github.com/mitchellh/go-vnc v0.0.0-20150629162542-723ed9867aed
 	github.com/mitchellh/mapstructure v1.4.1
-	github.com/zclconf/go-cty v1.8.0
+	github.com/zclconf/go-cty v1.9.1
 )